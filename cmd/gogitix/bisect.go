@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib"
+)
+
+// Finds the first commit in <good>..<bad> where a check fails, by checking out commits into workareas rather than
+// moving HEAD the way "git bisect" does
+func bisect(args []string) error {
+	flags := flag.NewFlagSet("bisect", flag.ContinueOnError)
+	check := flags.String("check", "", "name of the step to run (along with any steps it needs or comes after in a sequence)")
+	configFilePath := flags.String("config", "", "config file path (default: .gogitix.yml in the git root)")
+	flags.Var(&pathSpec, "path-spec", fmt.Sprintf("git path spec (default: %v)", DefaultPathSpec))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *check == "" || flags.NArg() != 1 || !strings.Contains(flags.Arg(0), "..") {
+		return errors.New("usage: gogitix bisect [--config path] [--path-spec spec]... --check <name> <good>..<bad>")
+	}
+	if len(pathSpec) == 0 {
+		pathSpec = make([]string, len(DefaultPathSpec))
+		copy(pathSpec, DefaultPathSpec)
+	}
+	parts := strings.SplitN(flags.Arg(0), "..", 2)
+	good, bad := parts[0], parts[1]
+	if bad == "" {
+		bad = "HEAD"
+	}
+
+	gitRoot, err := lib.RunGit(".", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	gitRoot = strings.TrimSpace(gitRoot)
+
+	commits, err := lib.BisectCommits(gitRoot, good, bad)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("%s is not an ancestor of %s", good, bad)
+	}
+
+	// Each run leaves us in its workarea, which it removes, so go back to where we started before the next one
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	onlyCheck = *check
+	// Leave the git index alone too, and only rewrite the files that differ between the commits we check
+	useWorktree = true
+	passes := func(sha string) (bool, error) {
+		if err := os.Chdir(dir); err != nil {
+			return false, err
+		}
+		// Check everything that changed since the good commit, so that each commit is checked the same way
+		err := run(*configFilePath, good+".."+sha, false)
+		switch err.(type) {
+		case nil:
+			return true, nil
+		case *lib.CommandFailedError, *lib.FailuresError:
+			if !lib.IsCanceled(err) {
+				return false, nil
+			}
+		}
+		return false, err
+	}
+
+	color.Yellow("Checking that %s fails at %s", *check, lib.DescribeCommit(gitRoot, bad))
+	if ok, err := passes(commits[len(commits)-1]); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%s passes at %s, so there is nothing to find", *check, bad)
+	}
+
+	// Everything before good is assumed to pass and the last commit fails
+	low, high := -1, len(commits)-1
+	for high-low > 1 {
+		mid := (low + high) / 2
+		color.Yellow("Bisecting: %d commit(s) left to check, checking %s", high-low-1, lib.DescribeCommit(gitRoot, commits[mid]))
+		ok, err := passes(commits[mid])
+		if err != nil {
+			return err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	color.Red("First failing commit for %s: %s", *check, lib.DescribeCommit(gitRoot, commits[high]))
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib"
+)
+
+// Runs the checks on a revision range, or on each commit in it in turn with -each-commit
+func runRange(configFilePath string, gitRevSpec string, useLndir bool) error {
+	if !eachCommit {
+		return run(configFilePath, gitRevSpec, useLndir)
+	}
+	if gitRevSpec == "" {
+		return errors.New("-each-commit needs a revision range")
+	}
+
+	gitRoot, err := lib.RunGit(".", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	gitRoot = strings.TrimSpace(gitRoot)
+
+	commits, err := lib.RangeCommits(gitRoot, gitRevSpec)
+	if err != nil {
+		return err
+	}
+
+	// Each run leaves us in its workarea, which it removes, so go back to where we started before the next one
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	var errs []error
+	for i, sha := range commits {
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+		commit := lib.DescribeCommit(gitRoot, sha)
+		color.Yellow("Checking commit %d of %d: %s", i+1, len(commits), commit)
+		if err := run(configFilePath, sha+"^!", useLndir); err != nil {
+			if !keepGoing {
+				color.Red("First failing commit: %s", commit)
+				return err
+			}
+			failed = append(failed, commit)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		color.Red("Failing commits (first is %s):\n  %s", failed[0], strings.Join(failed, "\n  "))
+	}
+	if len(errs) > 1 {
+		return &lib.FailuresError{Errs: errs, Of: "commits"}
+	} else if len(errs) == 1 {
+		return errs[0]
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib"
+)
+
+// Marks hooks written by "gogitix install" so we never overwrite or remove anyone else's
+const hookMarker = "# Installed by gogitix."
+
+// Suffix of a hook that was already installed when we installed ours, and which ours runs first
+const chainedHookSuffix = ".pre-gogitix"
+
+const hookHeader = `#!/bin/sh
+` + hookMarker + `  Remove it with "gogitix uninstall --hook {{ .Hook }}".
+
+gogitix={{ quote .Binary }}
+config={{ quote .Config }}
+
+run_gogitix() {
+  if [ -n "$config" ]; then
+    "$gogitix" -c "$config" "$@"
+  else
+    "$gogitix" "$@"
+  fi
+}
+`
+
+var hookTemplates = map[string]string{
+	"pre-commit": hookHeader + `{{ if .Chained }}
+# Run the hook that was here before
+"$(dirname "$0")/{{ .Hook }}` + chainedHookSuffix + `" "$@" || exit $?
+{{ end }}
+# Use the terminal for input, if there is one, so that reformat can ask before changing files
+if [ -t 1 ] && (exec < /dev/tty) 2>/dev/null; then
+  exec < /dev/tty
+fi
+
+run_gogitix -s
+`,
+
+	"commit-msg": hookHeader + `{{ if .Chained }}
+# Run the hook that was here before
+"$(dirname "$0")/{{ .Hook }}` + chainedHookSuffix + `" "$@" || exit $?
+{{ end }}
+# git passes the file with the commit message
+run_gogitix -commit-msg "$1"
+`,
+
+	"pre-push": hookHeader + `
+# git passes a "<local ref> <local sha> <remote ref> <remote sha>" line on stdin for each ref being pushed
+refs=$(cat)
+{{ if .Chained }}
+# Run the hook that was here before
+printf '%s\n' "$refs" | "$(dirname "$0")/{{ .Hook }}` + chainedHookSuffix + `" "$@" || exit $?
+{{ end }}
+printf '%s\n' "$refs" | run_gogitix -pre-push "$@"
+`,
+}
+
+// Extracts the settings from a hook we installed
+var hookBinaryRegexp = regexp.MustCompile(`(?m)^gogitix='((?:[^']|'\\'')*)'$`)
+var hookConfigRegexp = regexp.MustCompile(`(?m)^config='((?:[^']|'\\'')*)'$`)
+
+var subcommands = map[string]func(args []string) error{
+	"install":   install,
+	"uninstall": uninstall,
+	"doctor":    doctor,
+	"bisect":    bisect,
+	"version":   printVersion,
+}
+
+// Set with -ldflags "-X main.version=..."
+var version = "dev"
+
+func hookNames() []string {
+	var names []string
+	for name := range hookTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func shellUnquote(s string) string {
+	return strings.Replace(s, `'\''`, "'", -1)
+}
+
+// Returns an error unless name is a hook we can install
+func checkHookName(name string) error {
+	if _, found := hookTemplates[name]; !found {
+		return fmt.Errorf("unsupported hook '%s' (expected one of %s)", name, strings.Join(hookNames(), ", "))
+	}
+	return nil
+}
+
+// Returns the directory git runs hooks from in the current repository
+func gitHooksDir() (string, error) {
+	gitRoot, err := lib.RunGit(".", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	gitRoot = strings.TrimSpace(gitRoot)
+	hooksDir, err := lib.RunGit(gitRoot, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	hooksDir = strings.TrimSpace(hooksDir)
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(gitRoot, hooksDir)
+	}
+	return hooksDir, nil
+}
+
+func isGogitixHook(hookFile string) (installed bool, ours bool, err error) {
+	contents, err := ioutil.ReadFile(hookFile)
+	if os.IsNotExist(err) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	return true, bytes.Contains(contents, []byte(hookMarker)), nil
+}
+
+func install(args []string) error {
+	flags := flag.NewFlagSet("install", flag.ContinueOnError)
+	hook := flags.String("hook", "pre-commit", fmt.Sprintf("hook to install (%s)", strings.Join(hookNames(), ", ")))
+	configFilePath := flags.String("config", "", "config file path (default: .gogitix.yml in the git root)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := checkHookName(*hook); err != nil {
+		return err
+	}
+	hooksDir, err := gitHooksDir()
+	if err != nil {
+		return err
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to find gogitix binary: %s", err)
+	}
+	config := *configFilePath
+	if config != "" {
+		if config, err = filepath.Abs(config); err != nil {
+			return err
+		}
+	}
+	return installHook(hooksDir, *hook, binary, config)
+}
+
+// Writes the hook into hooksDir to run binary with config (if set), moving any hook that's already there aside so that
+// ours runs it first
+func installHook(hooksDir string, hook string, binary string, config string) error {
+	hookFile := filepath.Join(hooksDir, hook)
+	installed, ours, err := isGogitixHook(hookFile)
+	if err != nil {
+		return err
+	}
+	chainedHookFile := hookFile + chainedHookSuffix
+	_, chainedErr := os.Stat(chainedHookFile)
+	chained := chainedErr == nil
+	if installed && !ours {
+		if chained {
+			return fmt.Errorf("unable to keep existing %s hook because %s already exists", hook, chainedHookFile)
+		}
+		if err := os.Rename(hookFile, chainedHookFile); err != nil {
+			return err
+		}
+		color.Yellow("Moved existing %s hook to %s, which will run first", hook, chainedHookFile)
+		chained = true
+	}
+
+	hookTemplate := template.Must(template.New(hook).Funcs(template.FuncMap{"quote": shellQuote}).Parse(hookTemplates[hook]))
+	var script bytes.Buffer
+	if err := hookTemplate.Execute(&script, map[string]interface{}{
+		"Hook":    hook,
+		"Binary":  binary,
+		"Config":  config,
+		"Chained": chained,
+	}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(hookFile), os.ModePerm); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(hookFile, script.Bytes(), 0755); err != nil {
+		return err
+	}
+	// WriteFile doesn't change the mode of an existing file
+	if err := os.Chmod(hookFile, 0755); err != nil {
+		return err
+	}
+	color.Green("Installed %s hook at %s", hook, hookFile)
+	return nil
+}
+
+func uninstall(args []string) error {
+	flags := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	hook := flags.String("hook", "pre-commit", fmt.Sprintf("hook to uninstall (%s)", strings.Join(hookNames(), ", ")))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := checkHookName(*hook); err != nil {
+		return err
+	}
+	hooksDir, err := gitHooksDir()
+	if err != nil {
+		return err
+	}
+	return uninstallHook(hooksDir, *hook)
+}
+
+// Removes the hook from hooksDir if we installed it, putting back the hook that was there before
+func uninstallHook(hooksDir string, hook string) error {
+	hookFile := filepath.Join(hooksDir, hook)
+	installed, ours, err := isGogitixHook(hookFile)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("no %s hook is installed", hook)
+	}
+	if !ours {
+		return fmt.Errorf("the %s hook at %s was not installed by gogitix", hook, hookFile)
+	}
+
+	if err := os.Remove(hookFile); err != nil {
+		return err
+	}
+	color.Green("Removed %s hook from %s", hook, hookFile)
+
+	chainedHookFile := hookFile + chainedHookSuffix
+	if _, err := os.Stat(chainedHookFile); err == nil {
+		if err := os.Rename(chainedHookFile, hookFile); err != nil {
+			return err
+		}
+		color.Yellow("Restored the previous %s hook", hook)
+	}
+	return nil
+}
+
+// Checks that each hook we installed will work
+func doctor(args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	problems := 0
+	ok := func(format string, args ...interface{}) {
+		color.Green("  ok: "+format, args...)
+	}
+	problem := func(format string, args ...interface{}) {
+		color.Red("  problem: "+format, args...)
+		problems++
+	}
+
+	for _, tool := range []string{"git", "go"} {
+		if path, err := exec.LookPath(tool); err != nil {
+			problem("%s is not in PATH", tool)
+		} else {
+			ok("found %s at %s", tool, path)
+		}
+	}
+
+	hooksDir, err := gitHooksDir()
+	if err != nil {
+		return err
+	}
+	if err := checkHooks(hooksDir, ok, problem); err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// Reports, through ok and problem, whether each hook we installed in hooksDir will work
+func checkHooks(hooksDir string, ok func(format string, args ...interface{}), problem func(format string, args ...interface{})) error {
+	hooksFound := 0
+	for _, hook := range hookNames() {
+		hookFile := filepath.Join(hooksDir, hook)
+		installed, ours, err := isGogitixHook(hookFile)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			continue
+		}
+		color.Yellow("%s hook (%s):", hook, hookFile)
+		if !ours {
+			ok("installed, but not by gogitix")
+			continue
+		}
+		hooksFound++
+
+		if info, err := os.Stat(hookFile); err == nil && info.Mode()&0111 == 0 {
+			problem("hook is not executable, so git will ignore it")
+		}
+		if _, err := os.Stat(hookFile + chainedHookSuffix); err == nil {
+			ok("runs the previous hook at %s first", hookFile+chainedHookSuffix)
+		}
+
+		contents, err := ioutil.ReadFile(hookFile)
+		if err != nil {
+			return err
+		}
+		if match := hookBinaryRegexp.FindSubmatch(contents); match == nil {
+			problem("unable to find the gogitix binary in the hook")
+		} else {
+			binary := shellUnquote(string(match[1]))
+			if output, err := exec.Command(binary, "version").CombinedOutput(); err != nil { // #nosec
+				problem("%s doesn't work: %s", binary, err)
+			} else {
+				ok("runs %s (%s)", binary, strings.TrimSpace(string(output)))
+			}
+		}
+		if match := hookConfigRegexp.FindSubmatch(contents); match != nil && len(match[1]) > 0 {
+			config := shellUnquote(string(match[1]))
+			if _, err := os.Stat(config); err != nil {
+				problem("config file %s is missing", config)
+			} else {
+				ok("uses config file %s", config)
+			}
+		}
+	}
+
+	if hooksFound == 0 {
+		problem(`no gogitix hooks are installed (run "gogitix install")`)
+	}
+	return nil
+}
+
+func printVersion(args []string) error {
+	fmt.Printf("gogitix %s\n", version)
+	return nil
+}
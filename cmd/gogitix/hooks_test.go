@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib"
+)
+
+// Returns the hooks directory of a new git repo that is removed when the test finishes
+func newTestHooksDir(t *testing.T) string {
+	root, err := ioutil.TempDir("", "gogitix-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	if _, err := lib.RunGit(root, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(root, ".git", "hooks")
+}
+
+func readHook(t *testing.T, file string) string {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestInstallAndUninstallHook(t *testing.T) {
+	const existing = "#!/bin/sh\necho existing\n"
+	for _, hook := range hookNames() {
+		t.Run(hook, func(t *testing.T) {
+			hooksDir := newTestHooksDir(t)
+			hookFile := filepath.Join(hooksDir, hook)
+			assert.NoError(t, ioutil.WriteFile(hookFile, []byte(existing), 0755))
+
+			assert.NoError(t, installHook(hooksDir, hook, "/bin/gogitix", ""))
+			assert.Equal(t, existing, readHook(t, hookFile+chainedHookSuffix), "the existing hook is moved aside")
+			installed := readHook(t, hookFile)
+			assert.Contains(t, installed, hookMarker)
+			assert.Contains(t, installed, hook+chainedHookSuffix, "our hook runs the existing one")
+			info, err := os.Stat(hookFile)
+			if assert.NoError(t, err) {
+				assert.NotZero(t, info.Mode()&0111, "the hook is executable")
+			}
+
+			// Installing again replaces our hook without touching the existing one
+			assert.NoError(t, installHook(hooksDir, hook, "/bin/gogitix", "/etc/gogitix.yml"))
+			assert.Contains(t, readHook(t, hookFile), "config='/etc/gogitix.yml'")
+			assert.Equal(t, existing, readHook(t, hookFile+chainedHookSuffix))
+
+			assert.NoError(t, uninstallHook(hooksDir, hook))
+			assert.Equal(t, existing, readHook(t, hookFile), "the existing hook is restored")
+			assert.Equal(t, "<missing>", readHook(t, hookFile+chainedHookSuffix))
+		})
+	}
+}
+
+func TestInstallHookWithoutExistingHook(t *testing.T) {
+	hooksDir := newTestHooksDir(t)
+	hookFile := filepath.Join(hooksDir, "pre-commit")
+	os.RemoveAll(hooksDir)
+
+	assert.NoError(t, installHook(hooksDir, "pre-commit", "/bin/gogitix", ""))
+	assert.NotContains(t, readHook(t, hookFile), chainedHookSuffix)
+	assert.Equal(t, "<missing>", readHook(t, hookFile+chainedHookSuffix))
+
+	assert.NoError(t, uninstallHook(hooksDir, "pre-commit"))
+	assert.Equal(t, "<missing>", readHook(t, hookFile))
+	assert.Error(t, uninstallHook(hooksDir, "pre-commit"), "there is nothing left to uninstall")
+}
+
+func TestInstallHookKeepsChainedHook(t *testing.T) {
+	hooksDir := newTestHooksDir(t)
+	hookFile := filepath.Join(hooksDir, "pre-commit")
+	assert.NoError(t, ioutil.WriteFile(hookFile, []byte("#!/bin/sh\necho new\n"), 0755))
+	assert.NoError(t, ioutil.WriteFile(hookFile+chainedHookSuffix, []byte("#!/bin/sh\necho old\n"), 0755))
+
+	assert.Error(t, installHook(hooksDir, "pre-commit", "/bin/gogitix", ""))
+	assert.Equal(t, "#!/bin/sh\necho new\n", readHook(t, hookFile))
+	assert.Equal(t, "#!/bin/sh\necho old\n", readHook(t, hookFile+chainedHookSuffix))
+}
+
+func TestUninstallHookRefusesForeignHook(t *testing.T) {
+	hooksDir := newTestHooksDir(t)
+	hookFile := filepath.Join(hooksDir, "pre-commit")
+	assert.NoError(t, ioutil.WriteFile(hookFile, []byte("#!/bin/sh\necho foreign\n"), 0755))
+
+	err := uninstallHook(hooksDir, "pre-commit")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "was not installed by gogitix")
+	}
+	assert.Equal(t, "#!/bin/sh\necho foreign\n", readHook(t, hookFile))
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{"", "plain", "with space", "it's", "''", `a'\''b`, "/path/to/it's here/gogitix"} {
+		quoted := shellQuote(s)
+		assert.Equal(t, s, shellUnquote(quoted[1:len(quoted)-1]), s)
+
+		match := hookBinaryRegexp.FindStringSubmatch(fmt.Sprintf("#!/bin/sh\ngogitix=%s\nconfig=''\n", quoted))
+		if assert.NotNil(t, match, s) {
+			assert.Equal(t, s, shellUnquote(match[1]), s)
+		}
+	}
+}
+
+func TestCheckHooks(t *testing.T) {
+	hooksDir := newTestHooksDir(t)
+
+	// A binary and config with quotes in their paths, to check that the hook is read back correctly
+	dir := filepath.Join(filepath.Dir(filepath.Dir(hooksDir)), "it's here")
+	assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	binary := filepath.Join(dir, "gogitix")
+	assert.NoError(t, ioutil.WriteFile(binary, []byte("#!/bin/sh\necho gogitix test\n"), 0755))
+	config := filepath.Join(dir, "o'brien.yml")
+	assert.NoError(t, ioutil.WriteFile(config, []byte("- run: true\n"), 0644))
+
+	check := func() (oks []string, problems []string) {
+		err := checkHooks(hooksDir,
+			func(format string, args ...interface{}) { oks = append(oks, fmt.Sprintf(format, args...)) },
+			func(format string, args ...interface{}) { problems = append(problems, fmt.Sprintf(format, args...)) })
+		assert.NoError(t, err)
+		return oks, problems
+	}
+
+	_, problems := check()
+	assert.Equal(t, []string{`no gogitix hooks are installed (run "gogitix install")`}, problems)
+
+	assert.NoError(t, installHook(hooksDir, "pre-commit", binary, config))
+	oks, problems := check()
+	assert.Empty(t, problems)
+	assert.Equal(t, []string{"runs " + binary + " (gogitix test)", "uses config file " + config}, oks)
+
+	assert.NoError(t, os.Remove(config))
+	assert.NoError(t, os.Chmod(filepath.Join(hooksDir, "pre-commit"), 0644))
+	_, problems = check()
+	assert.Equal(t, []string{"hook is not executable, so git will ignore it", "config file " + config + " is missing"}, problems)
+
+	assert.NoError(t, os.Remove(binary))
+	_, problems = check()
+	if assert.Len(t, problems, 3) {
+		assert.True(t, strings.HasPrefix(problems[1], binary+" doesn't work"), problems[1])
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"regexp"
@@ -14,7 +16,10 @@ import (
 
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"gopkg.in/launchdarkly/gogitix.v2/lib"
 )
@@ -22,6 +27,26 @@ import (
 var debug = false
 var dryRun = false
 var staging = false
+var depth = 0
+var timeout time.Duration
+var keepGoing = false
+var maxParallel = 0
+var useCache = false
+var prePush = false
+var union = false
+var commitMessageFile string
+var eachCommit = false
+var useWorktree = false
+var useWarm = false
+var warmKeep FlagSlice
+var onlyCheck string // Name of the only step to run (along with the ones it needs or comes after), if set
+var junitReportPath string
+var sarifReportPath string
+var eventsPath string
+var format = "text"
+
+// Exit code when a check times out, as with timeout(1)
+const timeoutExitCode = 124
 
 var defaultFlow = `
 - parallel:
@@ -53,11 +78,39 @@ var pathSpec FlagSlice
 var revisionRangeRegexp = regexp.MustCompile(`\^[@!-]`)
 
 func main() {
+	if len(os.Args) > 1 {
+		if subcommand, found := subcommands[os.Args[1]]; found {
+			if err := subcommand(os.Args[2:]); err != nil {
+				if err != flag.ErrHelp {
+					color.Red("%s", err)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var configFilePath string
 	flag.BoolVar(&debug, "d", false, "debug")
 	flag.BoolVar(&dryRun, "n", false, "dry run")
 	flag.BoolVar(&staging, "s", false, "run changes on staging area")
 	flag.StringVar(&configFilePath, "c", "", "config file path")
+	flag.IntVar(&depth, "depth", 0, "levels of importers to include in .dependentPackages (0 for no limit)")
+	flag.DurationVar(&timeout, "timeout", 0, "default timeout for each command (e.g. 90s, 0 for none)")
+	flag.BoolVar(&keepGoing, "keep-going", false, "keep running checks after a failure")
+	flag.IntVar(&maxParallel, "j", 0, "most commands to run at once (0 for GOMAXPROCS)")
+	flag.BoolVar(&useCache, "cache", false, "skip commands that have already passed with the same inputs")
+	flag.StringVar(&junitReportPath, "report-junit", "", "write a JUnit XML report of the checks to this file")
+	flag.StringVar(&sarifReportPath, "report-sarif", "", "write the diagnostics found by the checks to this file as SARIF")
+	flag.StringVar(&eventsPath, "events", "", "write a newline-delimited JSON log of events to this file")
+	flag.StringVar(&format, "format", format, "output format: text, or json for newline-delimited JSON events")
+	flag.BoolVar(&prePush, "pre-push", false, "check the refs given to a pre-push hook on stdin (the arguments are the remote name and URL)")
+	flag.BoolVar(&union, "union", false, "with -pre-push, check all the refs at once rather than one at a time")
+	flag.StringVar(&commitMessageFile, "commit-msg", "", "run just the commit_message steps (and the steps they need) on the commit message in this file, as from a commit-msg hook")
+	flag.BoolVar(&eachCommit, "each-commit", false, "check each commit in the revision range on its own, oldest first")
+	flag.BoolVar(&useWorktree, "worktree", false, "check out into a git worktree that is reused between runs, rather than a temporary directory")
+	flag.BoolVar(&useWarm, "warm", false, "keep the workarea between runs, only updating the files that changed")
+	flag.Var(&warmKeep, "warm-keep", "with -warm, keep files matching this pattern (as in .gitignore) between runs, such as a cache")
 	useLndir := flag.Bool("lndir", false, "Use go-lndir or lndir if available")
 	flag.Var(&pathSpec, "path-spec", fmt.Sprintf("git path spec (default: %v)", DefaultPathSpec))
 
@@ -78,17 +131,104 @@ func main() {
 		}
 	}
 
+	// A commit-msg hook runs on the index that is being committed
+	if commitMessageFile != "" && gitRevSpec == "" {
+		staging = true
+	}
+
 	lib.SetDebug(debug)
 
-	gitRoot := strings.TrimSpace(lib.MustRunCmd("git", "rev-parse", "--show-toplevel"))
+	var err error
+	if prePush {
+		err = runPrePush(configFilePath, flag.Arg(0), *useLndir)
+	} else {
+		err = runRange(configFilePath, gitRevSpec, *useLndir)
+	}
+	if err != nil {
+		color.Red("%s", err)
+		if lib.TimedOut(err) {
+			os.Exit(timeoutExitCode)
+		}
+		os.Exit(1)
+	}
+}
 
-	ws, wsErr := lib.Start(gitRoot, pathSpec, *useLndir, gitRevSpec, staging)
-	if wsErr != nil {
-		lib.Failf(wsErr.Error())
+func run(configFilePath string, gitRevSpec string, useLndir bool) error {
+	gitRoot, err := lib.RunGit(".", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	gitRoot = strings.TrimSpace(gitRoot)
+
+	var observers lib.Observers
+	switch format {
+	case "text":
+		observers = append(observers, lib.ConsoleObserver{DryRun: dryRun})
+	case "json":
+		// Keep stdout for the events
+		color.Output = os.Stderr
+		observers = append(observers, lib.NewEventLog(os.Stdout))
+	default:
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+	if eventsPath != "" {
+		eventsFile, err := os.Create(eventsPath)
+		if err != nil {
+			return fmt.Errorf("unable to create events file: %s", err)
+		}
+		defer eventsFile.Close()
+		observers = append(observers, lib.NewEventLog(eventsFile))
+	}
+
+	var commitMessage *lib.CommitMessage
+	if commitMessageFile != "" {
+		msg, err := lib.ReadCommitMessage(commitMessageFile)
+		if err != nil {
+			return fmt.Errorf("unable to read commit message: %s", err)
+		}
+		commitMessage = &msg
+	}
+
+	strategy := lib.TempWorkarea
+	switch {
+	case useWorktree && useWarm:
+		return errors.New("-worktree and -warm can't be used together")
+	case useWorktree:
+		strategy = lib.WorktreeWorkarea
+	case useWarm:
+		strategy = lib.WarmWorkarea
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Stop running checks on Ctrl-C so that we can kill their processes and clean up the workarea.  This starts before
+	// the workarea is made so that an interrupted run still removes it (and gives back the lock on a reused one).
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			color.Red("Received %s, stopping checks...", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stopProgress := showProgress("Identifying changed files.")
+	ws, err := lib.StartWithStrategy(gitRoot, pathSpec, useLndir, gitRevSpec, staging, strategy, warmKeep)
+	stopProgress()
+	if err != nil {
+		return err
 	}
 
 	defer ws.Close()
 
+	if ctx.Err() != nil {
+		return errors.New("interrupted before running any checks")
+	}
+
 	if configFilePath == "" {
 		defaultConfigFilePath := filepath.Join(gitRoot, ".gogitix.yml")
 		color.Yellow("Using .gogitix.yml from git root")
@@ -99,13 +239,32 @@ func main() {
 
 	configFileRaw := []byte(defaultFlow)
 	if configFilePath != "" {
-		var err error
 		configFileRaw, err = ioutil.ReadFile(configFilePath)
 		if err != nil {
-			lib.Failf(`Unable to read config file "%s": %s`, flag.Arg(0), err)
+			return fmt.Errorf(`unable to read config file "%s": %s`, configFilePath, err)
 		}
 	}
 
+	modules := []map[string]interface{}{}
+	for _, m := range ws.UpdatedModules() {
+		modules = append(modules, map[string]interface{}{
+			"path":       m.Path,
+			"dir":        m.Dir,
+			"dirs":       m.UpdatedDirs,
+			"_dirs_":     strings.Join(m.UpdatedDirs, " "),
+			"packages":   m.UpdatedPackages,
+			"_packages_": strings.Join(m.UpdatedPackages, " "),
+		})
+	}
+
+	dependentPackages := ws.DependentPackages(depth)
+
+	// Empty unless we're checking a commit message
+	var message lib.CommitMessage
+	if commitMessage != nil {
+		message = *commitMessage
+	}
+
 	templateData := map[string]interface{}{
 		"files":      ws.UpdatedFiles,
 		"_files_":    strings.Join(ws.UpdatedFiles, " "),
@@ -117,60 +276,192 @@ func main() {
 		"_topDirs_":  strings.Join(ws.UpdatedTrees, " "),
 		"packages":   ws.UpdatedPackages,
 		"_packages_": strings.Join(ws.UpdatedPackages, " "),
+		"modules":    modules,
 		"gitRoot":    gitRoot,
 		"workRoot":   ws.WorkDir,
 		"root":       ws.RootDir,
+
+		"dependentPackages":   dependentPackages,
+		"_dependentPackages_": strings.Join(dependentPackages, " "),
+
+		"addedFiles":       ws.AddedFiles,
+		"_addedFiles_":     strings.Join(ws.AddedFiles, " "),
+		"modifiedFiles":    ws.ModifiedFiles,
+		"_modifiedFiles_":  strings.Join(ws.ModifiedFiles, " "),
+		"deletedFiles":     ws.DeletedFiles,
+		"_deletedFiles_":   strings.Join(ws.DeletedFiles, " "),
+		"renamedFiles":     ws.RenamedFiles,
+		"changedLines":     ws.ChangedLines,
+		"changedLinesFile": ws.ChangedLinesFile,
+
+		"commitMessage":  message.Message,
+		"commitSubject":  message.Subject,
+		"commitBody":     message.Body,
+		"commitTrailers": message.Trailers,
 	}
 
 	if debug {
 		data, _ := json.MarshalIndent(templateData, "", "  ")
-		fmt.Printf("Template data: %s\n", data)
+		fmt.Fprintf(color.Output, "Template data: %s\n", data)
+	}
+
+	configTemplate, err := template.New("config").Parse(string(configFileRaw))
+	if err != nil {
+		return fmt.Errorf("unable to parse config file template: %s", err)
 	}
 
 	var configFile bytes.Buffer
-	template.Must(template.New("config").Parse(string(configFileRaw))).Execute(&configFile, templateData)
+	if err := configTemplate.Execute(&configFile, templateData); err != nil {
+		return fmt.Errorf("unable to render config file template: %s", err)
+	}
 
 	var checks interface{}
 
 	func() {
 		defer func() {
 			if r := recover(); r != nil {
-				fmt.Printf("Panic while parsing config file:\n=======\n%s\n=======\n", configFile.Bytes())
+				fmt.Fprintf(color.Output, "Panic while parsing config file:\n=======\n%s\n=======\n", configFile.Bytes())
 				panic(r)
 			}
 		}()
-		if err := yaml.Unmarshal(configFile.Bytes(), &checks); err != nil {
-			lib.Failf(fmt.Sprintf("Unable to parse config file:\n=======\n%s\n=======\n", configFile.Bytes()))
-		}
+		err = yaml.Unmarshal(configFile.Bytes(), &checks)
 	}()
+	if err != nil {
+		return fmt.Errorf("unable to parse config file:\n=======\n%s\n=======\n", configFile.Bytes())
+	}
 
-	parsedCheck, parseError := lib.NewParser().Parse(checks, "")
-	if parseError != nil {
-		lib.Failf("Unable to parse config file: %s", parseError.Error())
+	parsedCheck, err := lib.NewParser().Parse(checks, "")
+	if err != nil {
+		return fmt.Errorf("unable to parse config file: %s", err)
+	}
+
+	if onlyCheck != "" {
+		if parsedCheck, err = lib.SelectCheck(parsedCheck, onlyCheck); err != nil {
+			return err
+		}
+	}
+	if commitMessage != nil {
+		// The pre-commit hook has already run the other checks
+		parsedCheck = lib.SelectCommitMessageChecks(parsedCheck)
+	} else {
+		parsedCheck = lib.WithoutCommitMessageChecks(parsedCheck)
 	}
 
 	color.Yellow("Running checks...")
 
-	// Don't do reformat unless we're just checking the index
-	skipReformat := gitRevSpec != ""
+	// Don't do reformat unless we're just checking the index, and not again once the commit message has been written
+	skipReformat := gitRevSpec != "" || commitMessage != nil
 
 	errResult := make(chan error)
 
-	go lib.RunCheck(ws, lib.CommandExecutor{DryRun: dryRun}, parsedCheck, staging, skipReformat, errResult)
+	var cache *lib.Cache
+	if useCache && !dryRun {
+		if cache, err = lib.NewCache(); err != nil {
+			return fmt.Errorf("unable to find cache directory: %s", err)
+		}
+	}
 
-	for {
-		if err, ok := <-errResult; !ok {
-			return
-		} else if err != nil {
-			lib.Failf(err.Error())
+	summary := lib.NewSummary()
+	runOptions := lib.RunOptions{
+		Staging:      staging,
+		SkipReformat: skipReformat,
+		KeepGoing:    keepGoing,
+		Summary:      summary,
+		Observer:     observers,
+		MaxParallel:  maxParallel,
+		Cache:        cache,
+
+		CommitMessage: commitMessage,
+	}
+
+	executor := lib.CommandExecutor{DryRun: dryRun, Timeout: timeout, Observer: observers}
+	go lib.RunCheck(ctx, ws, executor, parsedCheck, runOptions, errResult)
+
+	// Wait for everything to stop before returning, even after a failure, so nothing is left running
+	var failed []error
+	for err := range errResult {
+		if err == nil || lib.IsCanceled(err) && len(failed) > 0 {
+			continue
+		}
+		failed = append(failed, err)
+		if len(failed) == 1 && !keepGoing {
+			cancel()
+		}
+	}
+
+	runErr := lib.RunError(failed)
+
+	if junitReportPath != "" {
+		if err := writeJUnitReport(junitReportPath, parsedCheck, summary); err != nil {
+			return fmt.Errorf("unable to write JUnit report: %s", err)
+		}
+	}
+
+	if sarifReportPath != "" {
+		if err := writeSARIFReport(sarifReportPath, ws, parsedCheck, summary); err != nil {
+			return fmt.Errorf("unable to write SARIF report: %s", err)
 		}
 	}
+
+	return runErr
+}
+
+// Prints message followed by a dot every half second until the returned function is called
+func showProgress(message string) (stop func()) {
+	yellow := color.New(color.FgYellow)
+	yellow.Print(message)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				yellow.Print(".")
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+		yellow.Print("\n")
+	}
+}
+
+func writeJUnitReport(path string, check lib.Check, summary *lib.Summary) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := lib.WriteJUnitReport(file, check, summary); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeSARIFReport(path string, ws lib.Workspace, check lib.Check, summary *lib.Summary) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := lib.WriteSARIFReport(file, ws, check, summary); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
 type FlagSlice []string
 
 func (p *FlagSlice) String() string {
-	return strings.Join(pathSpec, " ")
+	return strings.Join(*p, " ")
 }
 
 func (p *FlagSlice) Set(s string) error {
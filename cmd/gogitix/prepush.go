@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib"
+)
+
+// Runs the checks on the commits git is about to push to remote, as described by the lines git passes to a pre-push
+// hook on stdin.  The checks run once for each ref, or once for all of them with -union.
+func runPrePush(configFilePath string, remote string, useLndir bool) error {
+	gitRoot, err := lib.RunGit(".", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	gitRoot = strings.TrimSpace(gitRoot)
+
+	pushedRefs, err := lib.ParsePushedRefs(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	var ranges []string
+	refsByRange := map[string][]string{}
+	for _, ref := range pushedRefs {
+		revRange, err := ref.Range(gitRoot, remote)
+		if err != nil {
+			return err
+		}
+		if revRange == "" {
+			color.Yellow("Nothing to check for %s", ref.LocalRef)
+			continue
+		}
+		if refsByRange[revRange] == nil {
+			ranges = append(ranges, revRange)
+		}
+		refsByRange[revRange] = append(refsByRange[revRange], ref.LocalRef)
+	}
+
+	if union && len(ranges) > 1 {
+		unionRange, err := lib.UnionRange(gitRoot, ranges)
+		if _, separate := err.(*lib.UnionRangeError); separate {
+			color.Yellow("%s, so checking each one on its own", err)
+		} else if err != nil {
+			return err
+		} else {
+			var refs []string
+			for _, revRange := range ranges {
+				refs = append(refs, refsByRange[revRange]...)
+			}
+			ranges = []string{unionRange}
+			refsByRange = map[string][]string{unionRange: refs}
+		}
+	}
+
+	// Each run leaves us in its workarea, which it removes, so go back to where we started before the next one
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var failed []error
+	for _, revRange := range ranges {
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+		color.Yellow("Checking %s (%s)", strings.Join(refsByRange[revRange], ", "), revRange)
+		if err := runRange(configFilePath, revRange, useLndir); err != nil {
+			if !keepGoing {
+				return err
+			}
+			color.Red("%s", err)
+			failed = append(failed, err)
+		}
+	}
+
+	if len(failed) > 1 {
+		return &lib.FailuresError{Errs: failed, Of: "refs"}
+	} else if len(failed) == 1 {
+		return failed[0]
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bump this when the way keys are made changes so that old entries are ignored
+const cacheVersion = "2"
+
+// Cache remembers commands that passed so they can be skipped when they are run again with exactly the same inputs.
+// The inputs of a command are its text and the settings that affect whether it passes, the environment variables that affect the go tool, and the
+// contents of its input paths in the git index (when checking staged changes) or in the revision being checked.
+// Commands run on the working tree are never cached.
+type Cache struct {
+	Dir string
+
+	lock   sync.Mutex
+	inputs map[string]string // Hash of the contents of each set of input paths, which don't change during a run
+}
+
+type cacheEntry struct {
+	Name     string    `json:"name"`
+	Output   []byte    `json:"output"`
+	Duration float64   `json:"duration"` // Seconds the command took when it passed
+	Time     time.Time `json:"time"`
+}
+
+// Returns a cache in the user's cache directory
+func NewCache() (*Cache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{Dir: filepath.Join(dir, "gogitix")}, nil
+}
+
+// Returns the key for the result of running cmd in ws, or false if its inputs can't be identified
+func (c *Cache) key(ws Workspace, cmd Command, staging bool) (string, bool) {
+	if c == nil || cmd.NoCache || (ws.Revision == "" && !staging) {
+		return "", false
+	}
+
+	contents, err := c.inputsHash(ws, cmd.Inputs, staging)
+	if err != nil {
+		return "", false
+	}
+
+	// The workarea has a different name every time, so leave it out
+	workDir := func(s string) string {
+		if ws.WorkDir == "" {
+			return s
+		}
+		return strings.Replace(s, ws.WorkDir, "$WORKDIR", -1)
+	}
+
+	// Only what affects whether the command passes, so that renaming or moving a step doesn't lose its entry
+	settings, err := json.Marshal(struct {
+		Command       string
+		Timeout       time.Duration
+		Filter        string
+		Diagnostics   string
+		ExpectSilence bool
+	}{cmd.Command, cmd.Timeout, cmd.Filter, cmd.Diagnostics, cmd.ExpectSilence})
+	if err != nil {
+		return "", false
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{cacheVersion, workDir(string(settings)), contents} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	for _, env := range cacheEnv() {
+		hash.Write([]byte(workDir(env)))
+		hash.Write([]byte{0})
+	}
+	if cmd.Filter != "" {
+		// What is reported depends on the lines that changed as well as what's in the files
+		changedLines, err := json.Marshal(ws.ChangedLines)
+		if err != nil {
+			return "", false
+		}
+		hash.Write(changedLines)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// The environment variables that affect the go tool, sorted
+func cacheEnv() []string {
+	var env []string
+	for _, v := range os.Environ() {
+		if strings.HasPrefix(v, "GO") || strings.HasPrefix(v, "CGO_") || strings.HasPrefix(v, "PATH=") {
+			env = append(env, v)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Hashes the object names of the files matching paths (or every file if there are none) in the index or revision
+func (c *Cache) inputsHash(ws Workspace, paths []string, staging bool) (string, error) {
+	id := strings.Join(paths, "\x00")
+	c.lock.Lock()
+	contents, found := c.inputs[id]
+	c.lock.Unlock()
+	if found {
+		return contents, nil
+	}
+
+	args := []string{"ls-files", "--stage"}
+	if !staging {
+		args = []string{"ls-tree", "-r", ws.Revision}
+	}
+	output, err := RunGit(ws.GitDir, append(append(args, "--"), paths...)...)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(output))
+	contents = hex.EncodeToString(hash[:])
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.inputs == nil {
+		c.inputs = map[string]string{}
+	}
+	c.inputs[id] = contents
+	return contents, nil
+}
+
+func (c *Cache) lookup(key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir, key))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+// Records that a command passed.  Failing to write to the cache only means the command will run again next time, so
+// errors are ignored.
+func (c *Cache) store(key string, result Result) {
+	data, err := json.Marshal(cacheEntry{Name: result.Name, Output: result.Output, Duration: seconds(result.Duration), Time: time.Now()})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return
+	}
+
+	// Write to a temporary file first so that nobody reads a partial entry
+	file, err := ioutil.TempFile(c.Dir, key+".tmp")
+	if err != nil {
+		return
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(c.Dir, key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+}
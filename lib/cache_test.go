@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	repo := newTestRepo(t)
+	gitRoot := repo.Root
+	stage := func(file, contents string) {
+		repo.write(gitRoot, file, contents)
+		repo.git("add", file)
+	}
+	stage("a.go", "package a")
+	stage("b.go", "package b")
+
+	cacheDir := filepath.Join(gitRoot, ".cache")
+	ws := Workspace{GitDir: gitRoot}
+	run := func(data string, staging bool) string {
+		check, err := parse(t, data)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		summary := NewSummary()
+		errs := make(chan error, 1)
+		opts := RunOptions{Staging: staging, Summary: summary, Cache: &Cache{Dir: cacheDir}, Observer: Observers{}}
+		RunCheck(context.Background(), ws, CommandExecutor{Observer: Observers{}}, check, opts, errs)
+		result, _ := summary.Result(check.Name())
+		return result.Status.String() + " " + string(result.Output)
+	}
+
+	assert.Equal(t, "PASS hello\n", run("echo hello", true))
+	assert.Equal(t, "CACHED hello\n", run("echo hello", true))
+	assert.Equal(t, "PASS hello\n", run("echo hello", false), "the working tree isn't cached")
+	assert.Equal(t, "PASS hello\n", run("run: {command: echo hello, no_cache: true}", true))
+	assert.Equal(t, "FAIL ", run("exit 1", true))
+	assert.Equal(t, "FAIL ", run("exit 1", true), "failures aren't cached")
+
+	assert.Equal(t, "PASS ", run("run: {command: true, inputs: [a.go]}", true))
+	stage("b.go", "package b // changed")
+	assert.Equal(t, "CACHED ", run("run: {command: true, inputs: [a.go]}", true))
+
+	stage("a.go", "package a // changed")
+	assert.Equal(t, "PASS ", run("run: {command: true, inputs: [a.go]}", true))
+	assert.Equal(t, "PASS hello\n", run("echo hello", true))
+	assert.Equal(t, "CACHED hello\n", run("run: {name: greet, description: Says hello, command: echo hello}", true),
+		"the name and description of a step don't matter")
+
+	// Cached commands are reported like any other, with how long they took when they passed
+	files, err := ioutil.ReadDir(cacheDir)
+	assert.NoError(t, err)
+	for _, file := range files {
+		entry := cacheEntry{Output: []byte("hello\n"), Duration: 42}
+		data, _ := json.Marshal(entry)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, file.Name()), data, 0644))
+	}
+	check, err := parse(t, "run: {name: greet, command: echo hello}")
+	assert.NoError(t, err)
+	observer := &recordingObserver{}
+	summary := NewSummary()
+	opts := RunOptions{Staging: true, Summary: summary, Cache: &Cache{Dir: cacheDir}, Observer: observer}
+	RunCheck(context.Background(), ws, CommandExecutor{Observer: observer}, check, opts, make(chan error, 1))
+	assert.Equal(t, []string{"ready", "start greet 0s", "output greet hello", "end greet CACHED", "run end <nil>"}, observer.events)
+	result, _ := summary.Result("greet")
+	assert.Equal(t, 42*time.Second, result.Duration)
+}
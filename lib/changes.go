@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type fileChanges struct {
+	added    []string          // Files that were added (or copied)
+	modified []string          // Files that were modified in place
+	deleted  []string          // Files that were deleted
+	renamed  map[string]string // New name of each renamed file, keyed by its old name
+}
+
+// Arguments to git diff that select the changes we are checking
+func diffBaseArgs(gitRevSpec string, staging bool) []string {
+	if gitRevSpec != "" {
+		return []string{gitRevSpec}
+	} else if staging {
+		return []string{"--cached"}
+	}
+	return []string{"HEAD"}
+}
+
+func getFileChanges(gitRoot string, pathSpec []string, gitRevSpec string, staging bool) (fileChanges, error) {
+	diffCmd := []string{"diff", "--name-status", "-M", "--diff-filter=ACDMR"}
+	diffCmd = append(diffCmd, diffBaseArgs(gitRevSpec, staging)...)
+	diffCmd = append(diffCmd, "--")
+	diffCmd = append(diffCmd, pathSpec...)
+	output, err := RunGit(gitRoot, diffCmd...)
+	if err != nil {
+		return fileChanges{}, err
+	}
+	return parseNameStatus(output), nil
+}
+
+// Returns a path as git writes it in diffs without its quotes, which git adds (with C-style escapes) to paths with
+// unusual characters in them
+func unquotePath(path string) string {
+	if strings.HasPrefix(path, `"`) {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return unquoted
+		}
+	}
+	return path
+}
+
+// Parses the output of git diff --name-status
+func parseNameStatus(output string) fileChanges {
+	changes := fileChanges{renamed: map[string]string{}}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		for i := 1; i < len(fields); i++ {
+			fields[i] = unquotePath(fields[i])
+		}
+		switch fields[0][0] {
+		case 'A':
+			changes.added = append(changes.added, fields[1])
+		case 'M':
+			changes.modified = append(changes.modified, fields[1])
+		case 'D':
+			changes.deleted = append(changes.deleted, fields[1])
+		case 'C':
+			changes.added = append(changes.added, fields[len(fields)-1])
+		case 'R':
+			if len(fields) == 3 {
+				changes.renamed[fields[1]] = fields[2]
+			}
+		}
+	}
+	return changes
+}
+
+// Files that have changed and still exist
+func (c fileChanges) updatedFiles() []string {
+	files := append(append([]string{}, c.added...), c.modified...)
+	for _, newName := range c.renamed {
+		files = append(files, newName)
+	}
+	return files
+}
+
+// Every path touched by the changes, including deleted files and the old names of renamed files
+func (c fileChanges) allFiles() []string {
+	files := append(c.updatedFiles(), c.deleted...)
+	for oldName := range c.renamed {
+		files = append(files, oldName)
+	}
+	return files
+}
+
+// An inclusive range of line numbers
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Returns true if line is within one of the ranges
+func containsLine(ranges []LineRange, line int) bool {
+	for _, r := range ranges {
+		if line >= r.Start && line <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the added or modified lines in each updated file, using the same base as getFileChanges
+func getChangedLines(gitRoot string, pathSpec []string, gitRevSpec string, staging bool) (map[string][]LineRange, error) {
+	diffCmd := []string{"diff", "-U0", "-M", "--no-color", "--no-ext-diff", "--no-prefix", "--diff-filter=ACMR"}
+	diffCmd = append(diffCmd, diffBaseArgs(gitRevSpec, staging)...)
+	diffCmd = append(diffCmd, "--")
+	diffCmd = append(diffCmd, pathSpec...)
+	output, err := RunGit(gitRoot, diffCmd...)
+	if err != nil {
+		return nil, err
+	}
+	return parseUnifiedDiff(output)
+}
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// Parses the output of git diff -U0 --no-prefix into the line ranges added to each new file
+func parseUnifiedDiff(output string) (map[string][]LineRange, error) {
+	changedLines := map[string][]LineRange{}
+	var file string
+	inHeader := false // Whether we're between "diff --git" and the first hunk, where added lines can't be
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "diff --git ") {
+			inHeader, file = true, ""
+			continue
+		}
+		if inHeader && strings.HasPrefix(line, "+++ ") {
+			// Names with spaces in them end with a tab
+			file = unquotePath(strings.TrimSuffix(strings.TrimPrefix(line, "+++ "), "\t"))
+			if file == "/dev/null" {
+				file = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "@@ ") {
+			inHeader = false
+		}
+		match := hunkHeaderRegexp.FindStringSubmatch(line)
+		if match == nil || file == "" {
+			continue
+		}
+		start, _ := strconv.Atoi(match[1])
+		count := 1
+		if match[2] != "" {
+			count, _ = strconv.Atoi(match[2])
+		}
+		// Hunks that only remove lines don't touch anything in the new file
+		if count > 0 {
+			changedLines[file] = append(changedLines[file], LineRange{Start: start, End: start + count - 1})
+		}
+	}
+	return changedLines, scanner.Err()
+}
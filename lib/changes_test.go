@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib/utils"
+)
+
+func TestParseNameStatus(t *testing.T) {
+	changes := parseNameStatus("A\tnew.go\nM\tlib/changed.go\nD\tgone.go\nR087\told/name.go\tnew/name.go\nC075\tsrc.go\tcopy.go\n" +
+		"A\t\"\\303\\251.go\"\n")
+
+	assert.Equal(t, []string{"new.go", "copy.go", "\u00e9.go"}, changes.added)
+	assert.Equal(t, []string{"lib/changed.go"}, changes.modified)
+	assert.Equal(t, []string{"gone.go"}, changes.deleted)
+	assert.Equal(t, map[string]string{"old/name.go": "new/name.go"}, changes.renamed)
+	assert.Equal(t, []string{"new.go", "copy.go", "\u00e9.go", "lib/changed.go", "new/name.go"}, changes.updatedFiles())
+	assert.Equal(t, []string{"copy.go", "gone.go", "lib/changed.go", "new.go", "new/name.go", "old/name.go", "\u00e9.go"},
+		utils.SortStrings(changes.allFiles()))
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	diff := `diff --git lib/a.go lib/a.go
+index 1111111..2222222 100644
+--- lib/a.go
++++ lib/a.go
+@@ -3 +3 @@ package a
+-var x = 1
++var x = 2
+@@ -10,0 +11,3 @@ func f() {
++	a()
++	b()
++	c()
+@@ -20,2 +23,0 @@ func g() {
+-	d()
+-	e()
+diff --git gone.go gone.go
+deleted file mode 100644
+--- gone.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package gone
+diff --git new.go new.go
+new file mode 100644
+--- /dev/null
++++ new.go
+@@ -0,0 +1,2 @@
++package new
++
+diff --git "q\"x.go" "q\"x.go"
+new file mode 100644
+--- /dev/null
++++ "q\"x.go"
+@@ -0,0 +1,2 @@
++++ looks like a header
++--- but isn't
+diff --git a b.go a b.go
+new file mode 100644
+--- /dev/null
++++ a b.go	
+@@ -0,0 +1 @@
++package a
+`
+	changedLines, err := parseUnifiedDiff(diff)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]LineRange{
+		"lib/a.go": {{Start: 3, End: 3}, {Start: 11, End: 13}},
+		"new.go":   {{Start: 1, End: 2}},
+		`q"x.go`:   {{Start: 1, End: 2}},
+		"a b.go":   {{Start: 1, End: 1}},
+	}, changedLines)
+
+	assert.True(t, containsLine(changedLines["lib/a.go"], 12))
+	assert.False(t, containsLine(changedLines["lib/a.go"], 14))
+
+	_, err = parseUnifiedDiff("diff --git a.go a.go\n+++ a.go\n@@ -0,0 +1 @@\n+" + strings.Repeat("x", 2*1024*1024) + "\n")
+	assert.Error(t, err, "lines that are too long aren't skipped silently")
+}
@@ -1,9 +1,18 @@
 package lib
 
+import "time"
+
 type Command struct {
-	Command       string `yaml:"command"`
-	Name          string `yaml:"name"`
-	Description   string `yaml:"description"`
-	ExpectSilence bool   `yaml:"expect_silence"`
-	Number        int    `yaml:"-"`
+	Command       string        `yaml:"command"`
+	Name          string        `yaml:"name"`
+	Description   string        `yaml:"description"`
+	ExpectSilence bool          `yaml:"expect_silence"`
+	Filter        string        `yaml:"filter"`
+	Diagnostics   string        `yaml:"diagnostics"` // Expression for finding diagnostics in the output
+	Timeout       time.Duration `yaml:"timeout"`     // Zero to use the executor's default
+	AllowFailure  bool          `yaml:"allow_failure"`
+	Needs         []string      `yaml:"needs"`  // Names of commands that must pass before this one runs
+	Inputs        []string      `yaml:"inputs"` // Paths the result depends on for caching, or empty for every file
+	NoCache       bool          `yaml:"no_cache"`
+	Number        int           `yaml:"-"`
 }
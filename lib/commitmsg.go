@@ -0,0 +1,326 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CommitMessage is a commit message being checked, split up the way git does
+type CommitMessage struct {
+	Message  string              // The whole message, without comments
+	Subject  string              // First paragraph of the message, on one line
+	Body     string              // The rest of the message, without the trailers
+	Trailers map[string][]string // Values of each trailer (e.g. "Signed-off-by") in the last paragraph
+}
+
+// Line git adds before the diff in the message of "git commit --verbose"
+const commitMessageScissors = "# ------------------------ >8 ------------------------"
+
+var trailerRegexp = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*)\s*:\s*(.*)$`)
+
+// Reads a commit message from a file, such as the one passed to a commit-msg hook
+func ReadCommitMessage(file string) (CommitMessage, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return CommitMessage{}, err
+	}
+	return ParseCommitMessage(string(data)), nil
+}
+
+// Splits a commit message into its subject, body and trailers, after removing the comments git adds for the editor
+func ParseCommitMessage(text string) CommitMessage {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line == commitMessageScissors {
+			break
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+
+	// Split into paragraphs, dropping the blank lines between them
+	var paragraphs [][]string
+	var paragraph []string
+	for _, line := range append(lines, "") {
+		if line != "" {
+			paragraph = append(paragraph, line)
+		} else if len(paragraph) > 0 {
+			paragraphs = append(paragraphs, paragraph)
+			paragraph = nil
+		}
+	}
+
+	msg := CommitMessage{Trailers: map[string][]string{}}
+	if len(paragraphs) == 0 {
+		return msg
+	}
+
+	msg.Message = joinParagraphs(paragraphs)
+	msg.Subject = strings.Join(paragraphs[0], " ")
+
+	// Like git, only look for trailers in the last paragraph, and only if every line of it is one
+	body := paragraphs[1:]
+	if len(body) > 0 {
+		last := body[len(body)-1]
+		trailers := map[string][]string{}
+		for _, line := range last {
+			match := trailerRegexp.FindStringSubmatch(line)
+			if match == nil {
+				trailers = nil
+				break
+			}
+			trailers[match[1]] = append(trailers[match[1]], match[2])
+		}
+		if trailers != nil {
+			msg.Trailers = trailers
+			body = body[:len(body)-1]
+		}
+	}
+	msg.Body = joinParagraphs(body)
+	return msg
+}
+
+func joinParagraphs(paragraphs [][]string) string {
+	var texts []string
+	for _, paragraph := range paragraphs {
+		texts = append(texts, strings.Join(paragraph, "\n"))
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// Returns the values of a trailer, ignoring the case of its key as git does
+func (msg CommitMessage) Trailer(key string) []string {
+	var values []string
+	for k, v := range msg.Trailers {
+		if strings.EqualFold(k, key) {
+			values = append(values, v...)
+		}
+	}
+	return values
+}
+
+// CommitMessageRules are what a commit message is checked against.  Unset rules aren't checked.
+type CommitMessageRules struct {
+	Pattern          string   `yaml:"pattern"`            // Expression the whole message must match
+	SubjectPattern   string   `yaml:"subject_pattern"`    // Expression the subject must match
+	MaxSubjectLength int      `yaml:"max_subject_length"` // Most characters in the subject
+	MaxLineLength    int      `yaml:"max_line_length"`    // Most characters in each line after the subject
+	RequiredTrailers []string `yaml:"required_trailers"`  // Trailers the message must have, e.g. "Signed-off-by"
+}
+
+func (rules CommitMessageRules) empty() bool {
+	return rules.Pattern == "" && rules.SubjectPattern == "" && rules.MaxSubjectLength == 0 && rules.MaxLineLength == 0 &&
+		len(rules.RequiredTrailers) == 0
+}
+
+// Describes the rules in a line, in place of a command
+func (rules CommitMessageRules) String() string {
+	var parts []string
+	if rules.Pattern != "" {
+		parts = append(parts, fmt.Sprintf("message matches %s", rules.Pattern))
+	}
+	if rules.SubjectPattern != "" {
+		parts = append(parts, fmt.Sprintf("subject matches %s", rules.SubjectPattern))
+	}
+	if rules.MaxSubjectLength > 0 {
+		parts = append(parts, fmt.Sprintf("subject is at most %d characters", rules.MaxSubjectLength))
+	}
+	if rules.MaxLineLength > 0 {
+		parts = append(parts, fmt.Sprintf("lines are at most %d characters", rules.MaxLineLength))
+	}
+	for _, trailer := range rules.RequiredTrailers {
+		parts = append(parts, fmt.Sprintf("has a '%s' trailer", trailer))
+	}
+	return "check that the commit message " + strings.Join(parts, ", ")
+}
+
+// Returns a description of each way msg breaks the rules
+func (rules CommitMessageRules) Problems(msg CommitMessage) ([]string, error) {
+	var problems []string
+	if rules.Pattern != "" {
+		re, err := regexp.Compile(rules.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		if !re.MatchString(msg.Message) {
+			problems = append(problems, fmt.Sprintf("message doesn't match %s", rules.Pattern))
+		}
+	}
+	if rules.SubjectPattern != "" {
+		re, err := regexp.Compile(rules.SubjectPattern)
+		if err != nil {
+			return nil, err
+		}
+		if !re.MatchString(msg.Subject) {
+			problems = append(problems, fmt.Sprintf("subject '%s' doesn't match %s", msg.Subject, rules.SubjectPattern))
+		}
+	}
+	if length := utf8.RuneCountInString(msg.Subject); rules.MaxSubjectLength > 0 && length > rules.MaxSubjectLength {
+		problems = append(problems, fmt.Sprintf("subject is %d characters long (at most %d allowed)", length, rules.MaxSubjectLength))
+	}
+	if rules.MaxLineLength > 0 {
+		// The subject (the first paragraph) has its own limit, so start after it
+		subjectLines := strings.Count(strings.SplitN(msg.Message, "\n\n", 2)[0], "\n") + 1
+		for i, line := range strings.Split(msg.Message, "\n") {
+			if i < subjectLines {
+				continue
+			}
+			if length := utf8.RuneCountInString(line); length > rules.MaxLineLength {
+				problems = append(problems, fmt.Sprintf("line %d is %d characters long (at most %d allowed)", i+1, length, rules.MaxLineLength))
+			}
+		}
+	}
+	for _, trailer := range rules.RequiredTrailers {
+		if len(msg.Trailer(trailer)) == 0 {
+			problems = append(problems, fmt.Sprintf("missing '%s' trailer", trailer))
+		}
+	}
+	return problems, nil
+}
+
+// CommitMessageCheck checks the commit message of a run against rules.  It is skipped if there is no commit message.
+type CommitMessageCheck struct {
+	Command Command // Name, description, needs and whether failure is allowed
+	Rules   CommitMessageRules
+}
+
+func (check CommitMessageCheck) Name() string {
+	return check.Command.Name
+}
+
+func (CommitMessageCheck) Children() []Check {
+	return nil
+}
+
+func (check CommitMessageCheck) CheckCommand() Command {
+	return check.Command
+}
+
+func (check CommitMessageCheck) Run(ctx context.Context, env Env) Result {
+	msg := env.Options.CommitMessage
+	if msg == nil || !env.Wait(ctx, check.Command.Needs) {
+		return env.Skipped(check.Name())
+	}
+
+	observer := observerOrConsole(env.Options.Observer, false)
+	observer.OnCheckStart(check.Command)
+	start := time.Now()
+	problems, err := check.Rules.Problems(*msg)
+	var output []byte
+	for _, problem := range problems {
+		observer.OnOutput(check.Command, problem)
+		output = append(output, problem+"\n"...)
+	}
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("found %d problem(s) with the commit message", len(problems))
+	}
+	if err != nil {
+		err = &CommandFailedError{Name: check.Name(), ExitCode: -1, Output: output, Duration: time.Since(start), Err: err}
+	}
+	result := Result{
+		Name:     check.Name(),
+		Status:   resultStatus(err, check.Command.AllowFailure),
+		Duration: time.Since(start),
+		Output:   output,
+		Err:      err,
+	}
+	observer.OnCheckEnd(check.Command, result)
+	return env.report(result)
+}
+
+func parseCommitMessageCheck(p Parser, value interface{}, path string) (Check, error) {
+	if _, ok := value.(map[interface{}]interface{}); !ok {
+		return nil, fmt.Errorf("expected an object of rules at %s", path)
+	}
+	var config struct {
+		Name               string   `yaml:"name"`
+		Description        string   `yaml:"description"`
+		AllowFailure       bool     `yaml:"allow_failure"`
+		Needs              []string `yaml:"needs"`
+		CommitMessageRules `yaml:",inline"`
+	}
+	if data, err := yaml.Marshal(value); err != nil {
+		return nil, fmt.Errorf("unable to parse commit message rules at %s: %s", path, err)
+	} else if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse commit message rules at %s: %s", path, err)
+	}
+
+	rules := config.CommitMessageRules
+	if rules.empty() {
+		return nil, fmt.Errorf("no commit message rules at %s", path)
+	}
+	for _, expr := range []string{rules.Pattern, rules.SubjectPattern} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid commit message expression at %s: %s", path, err)
+		}
+	}
+	if rules.MaxSubjectLength < 0 || rules.MaxLineLength < 0 {
+		return nil, fmt.Errorf("commit message lengths must not be negative at %s", path)
+	}
+
+	name := config.Name
+	if name == "" {
+		name = "commit_message"
+	}
+	return CommitMessageCheck{
+		Command: Command{
+			Command:      rules.String(),
+			Name:         p.UniqueName(name),
+			Description:  config.Description,
+			AllowFailure: config.AllowFailure,
+			Needs:        config.Needs,
+		},
+		Rules: rules,
+	}, nil
+}
+
+// Returns root with just the commit_message steps and the steps they need, for checking a commit message without
+// running every check again
+func SelectCommitMessageChecks(root Check) Check {
+	checks := map[string]Check{}
+	var names []string
+	walkChecks(root, "", func(check Check, path string) {
+		if check.Name() != "" {
+			checks[check.Name()] = check
+		}
+		if _, ok := check.(CommitMessageCheck); ok {
+			names = append(names, check.Name())
+		}
+	})
+	return selectChecks(root, names, func(name string) []string {
+		return checkNeeds(checks[name])
+	})
+}
+
+// Returns root without its commit_message steps, for runs that have no commit message to check.  Steps that need them
+// are skipped.
+func WithoutCommitMessageChecks(root Check) Check {
+	switch check := root.(type) {
+	case CommitMessageCheck:
+		return ManyChecks{}
+	case ManyChecks:
+		var checks []Check
+		for _, child := range check.Checks {
+			if _, ok := child.(CommitMessageCheck); !ok {
+				checks = append(checks, WithoutCommitMessageChecks(child))
+			}
+		}
+		check.Checks = checks
+		return check
+	default:
+		return root
+	}
+}
+
+func init() {
+	RegisterCheckType("commit_message", parseCommitMessageCheck)
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommitMessage(t *testing.T) {
+	msg := ParseCommitMessage(`Fix the thing
+that was broken
+
+It was broken because
+of reasons.
+
+Ticket: ABC-123
+Signed-off-by: A <a@example.com>
+signed-off-by: B <b@example.com>
+# Please enter the commit message for your changes.
+# ------------------------ >8 ------------------------
+diff --git a/a.go b/a.go
+`)
+	assert.Equal(t, "Fix the thing that was broken", msg.Subject)
+	assert.Equal(t, "It was broken because\nof reasons.", msg.Body)
+	assert.Equal(t, map[string][]string{
+		"Ticket":        {"ABC-123"},
+		"Signed-off-by": {"A <a@example.com>"},
+		"signed-off-by": {"B <b@example.com>"},
+	}, msg.Trailers)
+	assert.Equal(t, []string{"ABC-123"}, msg.Trailer("ticket"))
+	assert.Len(t, msg.Trailer("Signed-Off-By"), 2)
+	assert.Equal(t, "Fix the thing\nthat was broken\n\nIt was broken because\nof reasons.\n\n"+
+		"Ticket: ABC-123\nSigned-off-by: A <a@example.com>\nsigned-off-by: B <b@example.com>", msg.Message)
+
+	msg = ParseCommitMessage("Subject\n\nNot: a trailer\nbecause of this line\n")
+	assert.Equal(t, "Not: a trailer\nbecause of this line", msg.Body)
+	assert.Empty(t, msg.Trailers)
+
+	msg = ParseCommitMessage("Ticket: ABC-123\n")
+	assert.Equal(t, "Ticket: ABC-123", msg.Subject, "the subject is never a trailer")
+	assert.Empty(t, msg.Trailers)
+}
+
+func TestCommitMessageRules(t *testing.T) {
+	rules := CommitMessageRules{
+		SubjectPattern:   `^(feat|fix): `,
+		MaxSubjectLength: 20,
+		MaxLineLength:    10,
+		RequiredTrailers: []string{"Ticket"},
+	}
+	problems, err := rules.Problems(ParseCommitMessage("fix: short\n\nshort line\n\nTicket: 1\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = rules.Problems(ParseCommitMessage("Fixed a rather long subject\n\nshort line\nquite a long line\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"subject 'Fixed a rather long subject' doesn't match ^(feat|fix): ",
+		"subject is 27 characters long (at most 20 allowed)",
+		"line 4 is 17 characters long (at most 10 allowed)",
+		"missing 'Ticket' trailer",
+	}, problems)
+
+	problems, err = CommitMessageRules{Pattern: `(?m)^Closes #\d+$`}.Problems(ParseCommitMessage("fix\n\nCloses #12\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestCommitMessageCheck(t *testing.T) {
+	_, err := parse(t, `{commit_message: {name: msg}}`)
+	assert.EqualError(t, err, "no commit message rules at /commit_message")
+	_, err = parse(t, `{commit_message: {subject_pattern: "("}}`)
+	assert.Error(t, err)
+	_, err = parse(t, `{commit_message: true}`)
+	assert.EqualError(t, err, "expected an object of rules at /commit_message")
+
+	check, err := parse(t, `[{run: {name: build, command: "true"}}, {commit_message: {max_subject_length: 5, allow_failure: true}}, {commit_message: {required_trailers: [Ticket], needs: [build]}}]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, ManyChecks{Checks: []Check{
+		SingleCheck{Command: Command{Name: "build", Command: "true"}},
+		CommitMessageCheck{
+			Command: Command{Name: "commit_message", Command: "check that the commit message subject is at most 5 characters", AllowFailure: true},
+			Rules:   CommitMessageRules{MaxSubjectLength: 5},
+		},
+		CommitMessageCheck{
+			Command: Command{Name: "commit_message:2", Command: "check that the commit message has a 'Ticket' trailer", Needs: []string{"build"}},
+			Rules:   CommitMessageRules{RequiredTrailers: []string{"Ticket"}},
+		},
+	}}, check)
+}
+
+func TestSelectCommitMessageChecks(t *testing.T) {
+	check, err := parse(t, `[build, {parallel: [vet, {commit_message: {max_subject_length: 50}}, {commit_message: {name: ticket, required_trailers: [Ticket], needs: [build]}}]}, test]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"build", "commit_message", "ticket"}, commandNames(SelectCommitMessageChecks(check)))
+	assert.Equal(t, []string{"build", "vet", "test"}, commandNames(WithoutCommitMessageChecks(check)))
+
+	check, err = parse(t, `build`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, commandNames(SelectCommitMessageChecks(check)))
+	assert.Equal(t, check, WithoutCommitMessageChecks(check))
+}
+
+func TestRunCommitMessageCheck(t *testing.T) {
+	check, err := parse(t, `{commit_message: {name: msg, max_subject_length: 5}}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	run := func(msg *CommitMessage) Result {
+		summary := NewSummary()
+		errs := make(chan error, 1)
+		opts := RunOptions{Summary: summary, Observer: Observers{}, CommitMessage: msg}
+		RunCheck(context.Background(), Workspace{}, CommandExecutor{Observer: Observers{}}, check, opts, errs)
+		return summary.Results(check)[0]
+	}
+
+	msg := ParseCommitMessage("short")
+	assert.Equal(t, PASS, run(&msg).Status)
+	msg = ParseCommitMessage("much too long")
+	result := run(&msg)
+	assert.Equal(t, FAIL, result.Status)
+	assert.Equal(t, "subject is 13 characters long (at most 5 allowed)\n", string(result.Output))
+	assert.EqualError(t, result.Err, "msg failed: found 1 problem(s) with the commit message")
+	assert.Equal(t, SKIPPED, run(nil).Status, "skipped without a commit message")
+}
@@ -0,0 +1,31 @@
+package lib
+
+import "strings"
+
+// Returns the commits in a revision range, oldest first
+func RangeCommits(gitRoot string, gitRevSpec string) ([]string, error) {
+	output, err := RunGit(gitRoot, "rev-list", "--reverse", "--topo-order", gitRevSpec)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// Describes a commit in a line, with its short sha and subject
+func DescribeCommit(gitRoot string, sha string) string {
+	output, err := RunGit(gitRoot, "log", "-1", "--format=%h %s", sha)
+	if err != nil {
+		return sha
+	}
+	return strings.TrimSpace(output)
+}
+
+// Returns the commits from good (which isn't included) to bad along the first parents of bad, oldest first, which is
+// the line of history that bisecting searches
+func BisectCommits(gitRoot string, good string, bad string) ([]string, error) {
+	output, err := RunGit(gitRoot, "rev-list", "--reverse", "--first-parent", "--ancestry-path", good+".."+bad)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
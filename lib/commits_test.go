@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeCommits(t *testing.T) {
+	repo := newTestRepo(t)
+	gitRoot := repo.Root
+	first := repo.commit("first")
+	second := repo.commit("second")
+	third := repo.commit("third")
+
+	commits, err := RangeCommits(gitRoot, first+".."+third)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second, third}, commits)
+
+	commits, err = RangeCommits(gitRoot, second+"^!")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second}, commits)
+
+	assert.Equal(t, second[:7]+" second", DescribeCommit(gitRoot, second))
+
+	repo.git("checkout", "-q", "-b", "side", first)
+	side := repo.commit("side")
+	repo.git("checkout", "-q", "-")
+	repo.git("merge", "-q", "--no-ff", "-m", "merge", "side")
+	merge := repo.git("rev-parse", "HEAD")
+
+	commits, err = BisectCommits(gitRoot, first, merge)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second, third, merge}, commits, "only first parents are bisected")
+	assert.NotContains(t, commits, side)
+}
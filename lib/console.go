@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// ConsoleObserver prints the progress of a run in color, prefixing the lines about each command with its name
+type ConsoleObserver struct {
+	DryRun bool
+}
+
+// Colors of the commands that are running
+var consoleColorLock sync.Mutex
+var consoleColors = map[string]int{}
+
+func (ConsoleObserver) OnWorkspaceReady(ws Workspace) {}
+
+func (console ConsoleObserver) OnCheckStart(cmd Command) {
+	consoleColorLock.Lock()
+	color := checkoutColor()
+	consoleColors[cmd.Name] = color
+	consoleColorLock.Unlock()
+
+	msg := "Run"
+	if console.DryRun {
+		msg = "Would run"
+	}
+	if cmd.Description != "" {
+		msg += fmt.Sprintf(" [%s]", cmd.Description)
+	}
+	msg += ":"
+	if strings.Contains(strings.TrimSpace(cmd.Command), "\n") {
+		msg += "\n"
+	} else {
+		msg += " "
+	}
+	if strings.TrimSpace(cmd.Command) == "" {
+		msg += "<empty command>"
+	} else {
+		msg += cmd.Command
+	}
+	PrintCmdLine(INFO, cmd.Name, color, "%s", msg)
+
+	if console.DryRun {
+		PrintCmdLine(INFO, cmd.Name, color, "Would have run:\n=========\n%s\n========", shellScript(cmd))
+	}
+}
+
+// The console only shows output once a command has finished, so that the output of parallel commands isn't interleaved
+func (ConsoleObserver) OnOutput(cmd Command, line string) {}
+
+func (console ConsoleObserver) OnCheckEnd(cmd Command, result Result) {
+	consoleColorLock.Lock()
+	color, started := consoleColors[cmd.Name]
+	if !started {
+		// Observers don't have to be told about the start of every command
+		color = checkoutColor()
+	}
+	delete(consoleColors, cmd.Name)
+	consoleColorLock.Unlock()
+	defer releaseColor(color)
+
+	if console.DryRun {
+		return
+	}
+
+	if result.Ignored > 0 {
+		PrintCmdLine(INFO, cmd.Name, color, "Ignored %d diagnostic(s) outside of changed lines", result.Ignored)
+	}
+
+	err := result.Err
+	if failure, ok := err.(*CommandFailedError); ok {
+		err = failure.Err
+	}
+	switch result.Status {
+	case PASS:
+		PrintCmdLine(PASS, cmd.Name, color, "PASS (%0.3fs)", seconds(result.Duration))
+	case CACHED:
+		PrintCmdLine(CACHED, cmd.Name, color, "CACHED (passed before with the same inputs)")
+	case TIMEOUT:
+		PrintCmdLine(TIMEOUT, cmd.Name, color, "Command:\n%s\nPartial output:\n%s\nTIMEOUT after %s (%0.3fs)", cmd.Command, result.Output, cmd.Timeout, seconds(result.Duration))
+	case CANCELED:
+		PrintCmdLine(FAIL, cmd.Name, color, "CANCELED (%0.3fs)", seconds(result.Duration))
+	default:
+		PrintCmdLine(result.Status, cmd.Name, color, "Command:\n%s\nError: %s\nOutput:\n%s\n%s (%0.3fs)", cmd.Command, err, result.Output, result.Status, seconds(result.Duration))
+	}
+}
+
+func (ConsoleObserver) OnReformat(action string, files []string) {
+	switch action {
+	case ReformatSkippedUnstaged:
+		for _, file := range files {
+			color.Red("Did not automatically reformat '%s' because it has un-staged changes.", file)
+		}
+	case ReformatReformatting:
+		color.White("Automatically reformatting the following files:\n" + strings.Join(files, "\n"))
+	case ReformatWaitingForReview:
+		color.White("Press <Enter> to review changes. Hit Ctrl-C at any point to abort commit.")
+	case ReformatNotNeeded:
+		color.Green("No files need reformatting!")
+	}
+}
+
+func (ConsoleObserver) OnRunEnd(check Check, summary *Summary, err error) {
+	if summary != nil {
+		summary.Print(check)
+	}
+}
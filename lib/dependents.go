@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"strings"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib/utils"
+)
+
+// Prints each package followed by everything it imports, including from its tests
+const listImportsFormat = `{{.ImportPath}}{{range .Imports}} {{.}}{{end}}{{range .TestImports}} {{.}}{{end}}{{range .XTestImports}} {{.}}{{end}}`
+
+// Lists the packages under dir, mapping each one to the packages it imports
+func listPackages(dir string) (map[string][]string, error) {
+	output, err := RunCmdInDir(dir, "go", "list", "-e", "-f", listImportsFormat, "./...")
+	if err != nil {
+		return nil, &WorkspaceError{Op: "list packages in " + orRoot(dir), Err: cmdError(err, output)}
+	}
+	packages := map[string][]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		packages[fields[0]] = fields[1:]
+	}
+	return packages, nil
+}
+
+// Inverts a map of package imports so it maps each package to the packages that import it
+func importedBy(packages map[string][]string, importers map[string][]string) {
+	for p, imports := range packages {
+		for _, imported := range imports {
+			importers[imported] = append(importers[imported], p)
+		}
+	}
+}
+
+// Packages in the workspace that import one of the updated packages, directly or transitively.  If depth is positive,
+// only that many levels of importers are included.  Updated packages themselves are never included.
+func (ws Workspace) DependentPackages(depth int) []string {
+	seen := utils.StrMap(ws.UpdatedPackages)
+	dependents := map[string]bool{}
+	frontier := ws.UpdatedPackages
+	for level := 1; len(frontier) > 0 && (depth <= 0 || level <= depth); level++ {
+		var next []string
+		for _, p := range frontier {
+			for _, importer := range ws.importers[p] {
+				if !seen[importer] {
+					seen[importer] = true
+					dependents[importer] = true
+					next = append(next, importer)
+				}
+			}
+		}
+		frontier = next
+	}
+	return utils.SortStrings(utils.StrKeys(dependents))
+}
@@ -0,0 +1,23 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependentPackages(t *testing.T) {
+	importers := map[string][]string{}
+	importedBy(map[string][]string{
+		"a": {"fmt"},
+		"b": {"a", "fmt"},
+		"c": {"b"},
+		"d": {"c", "a"},
+		"e": {"fmt"},
+	}, importers)
+	ws := Workspace{UpdatedPackages: []string{"a"}, importers: importers}
+
+	assert.Equal(t, []string{"b", "c", "d"}, ws.DependentPackages(0))
+	assert.Equal(t, []string{"b", "d"}, ws.DependentPackages(1))
+	assert.Equal(t, []string{"b", "c", "d"}, ws.DependentPackages(2))
+}
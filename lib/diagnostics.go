@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches "file:line[:col]: message" as printed by go vet, staticcheck, golangci-lint and most other linters
+var diagnosticRegexp = regexp.MustCompile(`^\s*(?P<file>[^\s:]+):(?P<line>\d+)(?::(?P<col>\d+))?:\s*(?P<message>.*)$`)
+
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int // Zero if unknown
+	Message string
+}
+
+// Compiles a regular expression for finding diagnostics in the output of a command.  It must have "file" and "line"
+// groups and may have "col" and "message" groups.
+func compileDiagnosticRegexp(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, name := range re.SubexpNames() {
+		names[name] = true
+	}
+	if !names["file"] || !names["line"] {
+		return nil, fmt.Errorf("expression must have 'file' and 'line' groups, e.g. (?P<file>...)")
+	}
+	return re, nil
+}
+
+// The expression used to find diagnostics in the output of cmd
+func (cmd Command) diagnosticRegexp() *regexp.Regexp {
+	if cmd.Diagnostics != "" {
+		// The parser has already checked that this compiles
+		if re, err := compileDiagnosticRegexp(cmd.Diagnostics); err == nil {
+			return re
+		}
+	}
+	return diagnosticRegexp
+}
+
+func parseDiagnostic(re *regexp.Regexp, line string) (Diagnostic, bool) {
+	match := re.FindStringSubmatch(line)
+	if match == nil {
+		return Diagnostic{}, false
+	}
+	var diagnostic Diagnostic
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "file":
+			diagnostic.File = match[i]
+		case "line":
+			diagnostic.Line, _ = strconv.Atoi(match[i])
+		case "col":
+			diagnostic.Column, _ = strconv.Atoi(match[i])
+		case "message":
+			diagnostic.Message = strings.TrimSpace(match[i])
+		}
+	}
+	return diagnostic, diagnostic.File != ""
+}
+
+// Finds all the diagnostics in the output of a command
+func parseDiagnostics(re *regexp.Regexp, output []byte) (diagnostics []Diagnostic) {
+	for _, line := range strings.Split(string(output), "\n") {
+		if diagnostic, ok := parseDiagnostic(re, line); ok {
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+	return diagnostics
+}
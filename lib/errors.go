@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CommandFailedError is returned when a check command fails
+type CommandFailedError struct {
+	Name     string        // Name of the command
+	ExitCode int           // Exit code of the command, or -1 if it didn't exit normally
+	Output   []byte        // Combined stdout and stderr of the command
+	Duration time.Duration // How long the command ran
+	Err      error         // Why the command was considered failed
+}
+
+// ErrTimeout is the Err of a CommandFailedError for a command that ran past its timeout
+var ErrTimeout = errors.New("timed out")
+
+func (e *CommandFailedError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Name, e.Err)
+}
+
+func (e *CommandFailedError) TimedOut() bool {
+	return e.Err == ErrTimeout
+}
+
+// FailuresError is returned when more than one check fails, or the checks fail for more than one ref or commit
+type FailuresError struct {
+	Errs []error // Each failure, in the order they happened
+	Of   string  // What the checks failed for, such as "refs" or "commits", or empty for single checks
+}
+
+func (e *FailuresError) Error() string {
+	if e.Of == "" {
+		return fmt.Sprintf("%d checks failed", len(e.Errs))
+	}
+	return fmt.Sprintf("checks failed for %d %s", len(e.Errs), e.Of)
+}
+
+// Whether any of the failures was a command that timed out
+func (e *FailuresError) TimedOut() bool {
+	for _, err := range e.Errs {
+		if TimedOut(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the error of a run from the errors sent by RunCheck, leaving out those that aren't failures and the commands
+// that were canceled because of an earlier failure
+func RunError(errs []error) error {
+	var failed []error
+	for _, err := range errs {
+		if err == nil || IsCanceled(err) && len(failed) > 0 {
+			continue
+		}
+		failed = append(failed, err)
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return &FailuresError{Errs: failed}
+	}
+}
+
+// Returns true if err is from a command that timed out, or from failures that include one
+func TimedOut(err error) bool {
+	switch err := err.(type) {
+	case *CommandFailedError:
+		return err.TimedOut()
+	case *FailuresError:
+		return err.TimedOut()
+	}
+	return false
+}
+
+// GitError is returned when a git command fails
+type GitError struct {
+	Args   []string // Arguments to git
+	Output string   // Combined stdout and stderr of git
+	Err    error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s failed: %s\nOutput: %s", strings.Join(e.Args, " "), e.Err, e.Output)
+}
+
+// WorkspaceError is returned when the workarea can't be set up or updated
+type WorkspaceError struct {
+	Op  string // What we were trying to do
+	Err error
+}
+
+func (e *WorkspaceError) Error() string {
+	return fmt.Sprintf("unable to %s: %s", e.Op, e.Err)
+}
+
+// Returns true if err is from a command that was stopped because the run was canceled
+func IsCanceled(err error) bool {
+	failure, ok := err.(*CommandFailedError)
+	return ok && failure.Err == context.Canceled
+}
+
+// Returns the exit code of a process from the error returned by running it
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimedOut(t *testing.T) {
+	timedOut := &CommandFailedError{Name: "slow", Err: ErrTimeout}
+	failed := &CommandFailedError{Name: "broken", Err: errors.New("exit status 1")}
+
+	assert.True(t, TimedOut(timedOut))
+	assert.False(t, TimedOut(failed))
+	assert.False(t, TimedOut(errors.New("something else")))
+	assert.False(t, TimedOut(nil))
+
+	assert.True(t, TimedOut(&FailuresError{Errs: []error{failed, timedOut}}))
+	assert.False(t, TimedOut(&FailuresError{Errs: []error{failed, failed}}))
+	assert.True(t, TimedOut(&FailuresError{Errs: []error{failed, &FailuresError{Errs: []error{timedOut}}}, Of: "refs"}),
+		"failures of separate runs include the failures of each run")
+	assert.EqualError(t, &FailuresError{Errs: []error{failed, timedOut}}, "2 checks failed")
+	assert.EqualError(t, &FailuresError{Errs: []error{failed, timedOut}, Of: "commits"}, "checks failed for 2 commits")
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	EventWorkspaceStarted = "workspace_started"
+	EventCheckStarted     = "check_started"
+	EventOutput           = "output"
+	EventCheckFinished    = "check_finished"
+	EventReformat         = "reformat"
+	EventRunFinished      = "run_finished"
+)
+
+// Event is a single entry in the machine-readable log of a run
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Name        string    `json:"name,omitempty"`        // Name of the command
+	Description string    `json:"description,omitempty"` // Description of the command
+	Command     string    `json:"command,omitempty"`     // Text of the command
+	Line        string    `json:"line,omitempty"`        // A line of output
+	Status      string    `json:"status,omitempty"`      // Status of a finished check or run
+	ExitCode    *int      `json:"exitCode,omitempty"`
+	Duration    float64   `json:"duration,omitempty"` // In seconds
+	Error       string    `json:"error,omitempty"`
+	Action      string    `json:"action,omitempty"` // What reformat is doing
+	Files       []string  `json:"files,omitempty"`
+	Packages    []string  `json:"packages,omitempty"`
+	Dirs        []string  `json:"dirs,omitempty"`
+	Trees       []string  `json:"trees,omitempty"`
+	Failures    int       `json:"failures,omitempty"`
+}
+
+// EventLog is an observer that writes events as newline-delimited JSON.  A nil EventLog discards events.
+type EventLog struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	start   time.Time
+}
+
+func NewEventLog(w io.Writer) *EventLog {
+	return &EventLog{encoder: json.NewEncoder(w), start: time.Now()}
+}
+
+func (l *EventLog) Emit(event Event) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.encoder.Encode(event) // #nosec
+}
+
+func (l *EventLog) OnWorkspaceReady(ws Workspace) {
+	l.Emit(Event{
+		Type:     EventWorkspaceStarted,
+		Files:    ws.UpdatedFiles,
+		Packages: ws.UpdatedPackages,
+		Dirs:     ws.UpdatedDirs,
+		Trees:    ws.UpdatedTrees,
+	})
+}
+
+func (l *EventLog) OnCheckStart(cmd Command) {
+	l.Emit(Event{Type: EventCheckStarted, Name: cmd.Name, Description: cmd.Description, Command: cmd.Command})
+}
+
+func (l *EventLog) OnOutput(cmd Command, line string) {
+	l.Emit(Event{Type: EventOutput, Name: cmd.Name, Line: line})
+}
+
+func (l *EventLog) OnCheckEnd(cmd Command, result Result) {
+	event := Event{
+		Type:     EventCheckFinished,
+		Name:     cmd.Name,
+		Status:   result.Status.String(),
+		ExitCode: new(int),
+		Duration: seconds(result.Duration),
+	}
+	if result.Err != nil {
+		event.Error = result.Err.Error()
+		*event.ExitCode = -1
+		if failure, ok := result.Err.(*CommandFailedError); ok {
+			*event.ExitCode = failure.ExitCode
+		}
+	}
+	l.Emit(event)
+}
+
+func (l *EventLog) OnReformat(action string, files []string) {
+	l.Emit(Event{Type: EventReformat, Action: action, Files: files})
+}
+
+func (l *EventLog) OnRunEnd(check Check, summary *Summary, err error) {
+	if l == nil {
+		return
+	}
+	event := Event{Type: EventRunFinished, Status: PASS.String(), Duration: seconds(time.Since(l.start))}
+	if err != nil {
+		event.Status = FAIL.String()
+		event.Error = err.Error()
+	}
+	if summary != nil {
+		for _, result := range summary.Results(check) {
+			if result.Status == FAIL || result.Status == TIMEOUT {
+				event.Failures++
+			}
+		}
+	}
+	l.Emit(event)
+}
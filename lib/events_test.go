@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventLogOutputLines(t *testing.T) {
+	var buf bytes.Buffer
+	writer := outputWriter(NewEventLog(&buf), Command{Name: "vet"})
+	writer.Write([]byte("first\nsec"))
+	writer.Write([]byte("ond\nthird"))
+	writer.Flush()
+
+	var lines []string
+	for _, data := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event Event
+		if assert.NoError(t, json.Unmarshal([]byte(data), &event)) {
+			assert.Equal(t, EventOutput, event.Type)
+			assert.Equal(t, "vet", event.Name)
+			lines = append(lines, event.Line)
+		}
+	}
+	assert.Equal(t, []string{"first", "second", "third"}, lines)
+}
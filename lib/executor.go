@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -14,12 +15,14 @@ import (
 )
 
 type Executor interface {
-	Execute(ws Workspace, cmd Command) error
-	ExecuteWithOutput(ws Workspace, cmd Command) ([]byte, error)
+	Execute(ctx context.Context, ws Workspace, cmd Command) error
+	ExecuteWithOutput(ctx context.Context, ws Workspace, cmd Command) ([]byte, error)
 }
 
 type CommandExecutor struct {
-	DryRun bool
+	DryRun   bool
+	Timeout  time.Duration // Default timeout for commands that don't specify one, or zero for none
+	Observer Observer      // Told about each command as it runs, or nil to print to the console
 }
 
 var CmdColors = []color.Attribute{
@@ -36,78 +39,137 @@ const (
 	PASS CmdStatus = iota
 	FAIL
 	INFO
+	TIMEOUT
+	SKIPPED
+	ALLOWED_FAILURE
+	CANCELED
+	CACHED
 )
 
 var StatusToColor = map[CmdStatus]color.Attribute{
-	PASS: color.FgGreen,
-	FAIL: color.FgRed,
-	INFO: color.FgCyan,
+	PASS:            color.FgGreen,
+	FAIL:            color.FgRed,
+	INFO:            color.FgCyan,
+	TIMEOUT:         color.FgRed,
+	SKIPPED:         color.FgYellow,
+	ALLOWED_FAILURE: color.FgYellow,
+	CANCELED:        color.FgRed,
+	CACHED:          color.FgGreen,
+}
+
+var statusNames = map[CmdStatus]string{
+	PASS:            "PASS",
+	FAIL:            "FAIL",
+	INFO:            "INFO",
+	TIMEOUT:         "TIMEOUT",
+	SKIPPED:         "SKIPPED",
+	ALLOWED_FAILURE: "ALLOWED-FAILURE",
+	CANCELED:        "CANCELED",
+	CACHED:          "CACHED",
+}
+
+func (s CmdStatus) String() string {
+	return statusNames[s]
 }
 
 // Run Command returning output and error status
-func (executor CommandExecutor) Execute(ws Workspace, cmd Command) error {
-	_, err := executor.ExecuteWithOutput(ws, cmd)
+func (executor CommandExecutor) Execute(ctx context.Context, ws Workspace, cmd Command) error {
+	_, err := executor.ExecuteWithOutput(ctx, ws, cmd)
 	return err
 }
 
-func (executor CommandExecutor) ExecuteWithOutput(ws Workspace, cmd Command) ([]byte, error) {
-	color := checkoutColor()
-	defer releaseColor(color)
+// Runs the command, stopping it and everything it started if ctx is done first
+func (executor CommandExecutor) ExecuteWithOutput(ctx context.Context, ws Workspace, cmd Command) ([]byte, error) {
+	observer := observerOrConsole(executor.Observer, executor.DryRun)
+	if cmd.Timeout == 0 {
+		cmd.Timeout = executor.Timeout
+	}
 
+	observer.OnCheckStart(cmd)
+	start := time.Now()
+	output, ignored, err := executor.execute(ctx, ws, cmd, observer)
+	observer.OnCheckEnd(cmd, Result{
+		Name:     cmd.Name,
+		Status:   resultStatus(err, cmd.AllowFailure),
+		Duration: time.Since(start),
+		Output:   output,
+		Err:      err,
+		Ignored:  ignored,
+	})
+	return output, err
+}
+
+// The script run by bash for cmd
+func shellScript(cmd Command) string {
+	return "set -e\n" + cmd.Command
+}
+
+func (executor CommandExecutor) execute(ctx context.Context, ws Workspace, cmd Command, observer Observer) ([]byte, int, error) {
 	output := []byte{}
+	if executor.DryRun {
+		return output, 0, nil
+	}
+
 	file, err := ioutil.TempFile("", cmd.Name)
 	if err != nil {
-		return output, err
+		return output, 0, err
 	}
 
-	file.Write([]byte("set -e\n"))
-	file.Write([]byte(cmd.Command))
+	script := shellScript(cmd)
+	dirFile := file.Name() + ".dir"
+	if cmd.Filter == FilterChangedLines {
+		// Record where the command finished, since that is what the paths in its diagnostics are relative to
+		script = "trap 'pwd -P > \"$0.dir\"' EXIT\n" + script
+		defer os.Remove(dirFile)
+	}
+	file.Write([]byte(script))
 	file.Close()
 	defer os.Remove(file.Name())
 
 	start := time.Now()
 	shellCmd := exec.Command("/bin/bash", file.Name()) /* #nosec */
-
-	msg := "Run"
-	if executor.DryRun {
-		msg = "Would run"
-	}
-	if cmd.Description != "" {
-		msg += fmt.Sprintf(" [%s]", cmd.Description)
+	shellCmd.Dir = ws.RootDir
+	if ws.worktree {
+		shellCmd.Env = worktreeEnv()
 	}
-	msg += ":"
-	if strings.Contains(strings.TrimSpace(cmd.Command), "\n") {
-		msg += "\n"
-	} else {
-		msg += " "
-	}
-	if strings.TrimSpace(cmd.Command) == "" {
-		msg += "<empty command>"
-	} else {
-		msg += cmd.Command
+
+	cmdCtx := ctx
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
 	}
-	PrintCmdLine(INFO, cmd.Name, color, "%s", msg)
 
-	if executor.DryRun {
-		if contents, err := ioutil.ReadFile(file.Name()); err != nil {
-			return output, err
-		} else {
-			PrintCmdLine(INFO, cmd.Name, color, "Would have run:\n=========\n%s\n========", contents)
+	tee := outputWriter(observer, cmd)
+	defer tee.Flush()
+	output, err = runInProcessGroup(cmdCtx, shellCmd, tee)
+	duration := time.Since(start)
+	code := exitCode(err)
+	var ignored int
+	switch {
+	case ctx.Err() == nil && cmdCtx.Err() == context.DeadlineExceeded:
+		err = ErrTimeout
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	default:
+		if cmd.Filter == FilterChangedLines {
+			dir, _ := ioutil.ReadFile(dirFile)
+			output, ignored, err = filterChangedLines(ws, strings.TrimSpace(string(dir)), cmd.diagnosticRegexp(), output, err)
 		}
-	} else {
-		output, err = shellCmd.CombinedOutput()
-		duration := time.Since(start)
 		if err == nil && cmd.ExpectSilence && strings.TrimSpace(string(output)) != "" {
 			err = errors.New("expected no output but output was present")
 		}
-		if err != nil {
-			PrintCmdLine(FAIL, cmd.Name, color, "Command:\n%s\nError: %s\nOutput:\n%s\nFAIL (%0.3fs)", cmd.Command, err, output, seconds(duration))
-			os.Exit(1)
-		} else {
-			PrintCmdLine(PASS, cmd.Name, color, "PASS (%0.3fs)", seconds(duration))
+	}
+	if err != nil {
+		return output, ignored, &CommandFailedError{
+			Name:     cmd.Name,
+			ExitCode: code,
+			Output:   output,
+			Duration: duration,
+			Err:      err,
 		}
 	}
-	return output, nil
+	return output, ignored, nil
 }
 
 func PrintCmdLine(status CmdStatus, name string, colorNum int, format string, args ...interface{}) {
@@ -115,7 +177,7 @@ func PrintCmdLine(status CmdStatus, name string, colorNum int, format string, ar
 	cmdColor := color.New(CmdColors[colorNum])
 	output := fmt.Sprintf(format, args...)
 	for _, line := range strings.Split(output, "\n") {
-		fmt.Print(cmdColor.Sprint("> " + name + " | " + line + "\n"))
+		fmt.Fprint(color.Output, cmdColor.Sprint("> "+name+" | "+line+"\n"))
 	}
 }
 
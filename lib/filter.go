@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Only report diagnostics on lines changed in the workspace
+const FilterChangedLines = "changed-lines"
+
+// Returns the path of file relative to the root of the workspace.  A relative file is taken to be relative to dir, the
+// directory of the command that reported it, or to the root if dir is empty.
+func (ws Workspace) relativePath(dir string, file string) string {
+	if !filepath.IsAbs(file) && dir != "" {
+		file = filepath.Join(dir, file)
+	}
+	if filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(ws.RootDir, file); err == nil {
+			return rel
+		}
+	}
+	return filepath.Clean(file)
+}
+
+// Whether file, relative to the root of the workspace, is one that has changed or is in the workarea
+func (ws Workspace) knownFile(file string) bool {
+	if _, found := ws.ChangedLines[file]; found {
+		return true
+	}
+	if filepath.IsAbs(file) || file == ".." || strings.HasPrefix(file, ".."+string(filepath.Separator)) {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(ws.RootDir, file))
+	return err == nil && !info.IsDir()
+}
+
+// Returns the diagnostics in output that are on changed lines, the total number of diagnostics found and how many of
+// those are in files we know about.  dir is the directory the command was in when it finished.
+func (ws Workspace) diagnosticsOnChangedLines(dir string, re *regexp.Regexp, output []byte) (kept []string, found int, known int) {
+	for _, line := range strings.Split(string(output), "\n") {
+		diagnostic, ok := parseDiagnostic(re, line)
+		if !ok {
+			continue
+		}
+		found++
+		file := ws.relativePath(dir, diagnostic.File)
+		if ws.knownFile(file) {
+			known++
+		}
+		if containsLine(ws.ChangedLines[file], diagnostic.Line) {
+			kept = append(kept, line)
+		}
+	}
+	return kept, found, known
+}
+
+// Reduces the output of a command to the diagnostics on changed lines, failing only if there are any.  Output with no
+// recognizable diagnostics, or none in files we know about (which means we've misread their paths), is passed through
+// with its original error.
+func filterChangedLines(ws Workspace, dir string, re *regexp.Regexp, output []byte, err error) ([]byte, int, error) {
+	kept, found, known := ws.diagnosticsOnChangedLines(dir, re, output)
+	if found == 0 || known == 0 {
+		return output, 0, err
+	}
+
+	filtered := []byte(strings.Join(kept, "\n"))
+	if len(kept) > 0 {
+		return filtered, found - len(kept), fmt.Errorf("%d diagnostic(s) on changed lines", len(kept))
+	}
+	return filtered, found, nil
+}
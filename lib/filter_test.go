@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterChangedLines(t *testing.T) {
+	ws := Workspace{
+		RootDir:      "/work/src/project",
+		ChangedLines: map[string][]LineRange{"lib/a.go": {{Start: 10, End: 12}}},
+	}
+	failed := errors.New("exit status 1")
+
+	output, ignored, err := filterChangedLines(ws, "", diagnosticRegexp, []byte("# lib\nlib/a.go:3:1: old problem\n./lib/a.go:11: new problem\n/work/src/project/lib/a.go:12:5: another\nlib/b.go:11:1: elsewhere\n"), failed)
+	assert.EqualError(t, err, "2 diagnostic(s) on changed lines")
+	assert.Equal(t, "./lib/a.go:11: new problem\n/work/src/project/lib/a.go:12:5: another", string(output))
+	assert.Equal(t, 2, ignored)
+
+	output, ignored, err = filterChangedLines(ws, "", diagnosticRegexp, []byte("lib/a.go:3:1: old problem\n"), failed)
+	assert.NoError(t, err)
+	assert.Equal(t, "", string(output))
+	assert.Equal(t, 1, ignored)
+
+	output, _, err = filterChangedLines(ws, "", diagnosticRegexp, []byte("panic: something broke\n"), failed)
+	assert.Equal(t, failed, err)
+	assert.Equal(t, "panic: something broke\n", string(output))
+
+	// Paths that don't lead to any file we know about have been misread, so the command fails as it would have
+	output, _, err = filterChangedLines(ws, "", diagnosticRegexp, []byte("a.go:11:1: problem\n"), failed)
+	assert.Equal(t, failed, err)
+	assert.Equal(t, "a.go:11:1: problem\n", string(output))
+
+	// Relative paths are relative to where the command was
+	output, ignored, err = filterChangedLines(ws, "/work/src/project/lib", diagnosticRegexp, []byte("a.go:11:1: problem\na.go:3:1: old problem\n"), failed)
+	assert.EqualError(t, err, "1 diagnostic(s) on changed lines")
+	assert.Equal(t, "a.go:11:1: problem", string(output))
+	assert.Equal(t, 1, ignored)
+}
+
+func TestFilterChangedLinesInNestedModule(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "gogitix-filter-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(rootDir)
+	rootDir, _ = filepath.EvalSymlinks(rootDir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(rootDir, "nested", "lib"), os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(rootDir, "nested", "lib", "a.go"), []byte("package lib"), 0644))
+
+	ws := Workspace{
+		RootDir:      rootDir,
+		ChangedLines: map[string][]LineRange{"nested/lib/a.go": {{Start: 10, End: 12}}},
+	}
+	executor := CommandExecutor{Observer: Observers{}}
+
+	// Tools run from inside a nested module report paths relative to it
+	cmd := Command{Name: "vet", Filter: FilterChangedLines, Command: "cd nested && echo 'lib/a.go:11:2: new problem' && exit 1"}
+	output, err := executor.ExecuteWithOutput(context.Background(), ws, cmd)
+	assert.Error(t, err)
+	assert.Equal(t, "lib/a.go:11:2: new problem", string(output))
+
+	cmd.Command = "cd nested && echo 'lib/a.go:3:2: old problem' && exit 1"
+	_, err = executor.ExecuteWithOutput(context.Background(), ws, cmd)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A git repo in a temporary directory that is removed when the test finishes
+type testRepo struct {
+	t    *testing.T
+	Root string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	root, err := ioutil.TempDir("", "gogitix-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	root, _ = filepath.EvalSymlinks(root)
+
+	repo := &testRepo{t: t, Root: root}
+	repo.git("init", "-q")
+	repo.git("config", "user.name", "test")
+	repo.git("config", "user.email", "test@example.com")
+	return repo
+}
+
+// Runs git in the repo, returning its trimmed output
+func (repo *testRepo) git(args ...string) string {
+	output, err := RunGit(repo.Root, args...)
+	assert.NoError(repo.t, err)
+	return strings.TrimSpace(output)
+}
+
+// Commits whatever is staged (which may be nothing) and returns the sha of the commit
+func (repo *testRepo) commit(message string) string {
+	repo.git("commit", "-q", "--allow-empty", "-m", message)
+	return repo.git("rev-parse", "HEAD")
+}
+
+// Writes file in dir, which is usually the root of the repo or a workarea, making any directories it needs
+func (repo *testRepo) write(dir, file, contents string) {
+	assert.NoError(repo.t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), os.ModePerm))
+	assert.NoError(repo.t, ioutil.WriteFile(filepath.Join(dir, file), []byte(contents), 0644))
+}
+
+// Reads file in dir, or returns "<missing>" if there isn't one
+func (repo *testRepo) read(dir, file string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	assert.NoError(repo.t, err)
+	return string(data)
+}
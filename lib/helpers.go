@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
@@ -16,55 +17,53 @@ func SetDebug(d bool) {
 	debug = d
 }
 
-func MustRunCmd(name string, args ...string) string {
-	if output, err := RunCmd(name, args...); err != nil {
-		cmd := strings.Join(append([]string{name}, args...), " ")
-		Failf(fmt.Sprintf("Command failed: %s\nError: %s\nOutput: %s\n", cmd, err, output))
-		return ""
-	} else {
-		return output
+// Run git in gitRoot, returning a GitError if it fails
+func RunGit(gitRoot string, args ...string) (string, error) {
+	output, err := RunCmd("git", append([]string{"-C", gitRoot}, args...)...)
+	if err != nil {
+		return output, &GitError{Args: args, Output: output, Err: err}
 	}
+	return output, nil
 }
 
-func Failf(msg string, args ...interface{}) {
-	color.Red(msg, args...)
-	os.Exit(1)
-}
-
-func MustRunTestCmd(msg string, name string, args ...string) {
-	if msg != "" {
-		color.Yellow("%s... ", msg)
+// Runs git with indexFile as the index, or the usual one if indexFile is empty
+func runIndexGit(gitRoot string, indexFile string, stdin []byte, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", gitRoot}, args...)...) // #nosec
+	if indexFile != "" {
+		cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+indexFile)
+	}
+	cmd.Stdin = bytes.NewReader(stdin)
+	if debug {
+		color.Magenta("[DEBUG] running 'git -C %s %s'", gitRoot, strings.Join(args, " "))
 	}
-	start := time.Now()
-	cmdDetails := strings.Join(append([]string{name}, args...), " ")
-	color.Cyan(cmdDetails)
-	cmd := exec.Command(name, args...) // #nosec
 	output, err := cmd.CombinedOutput()
-	duration := time.Since(start)
 	if err != nil {
-		color.Red("Command: %s,\nError: %s\nOutput:\n%s\nFAIL (%0.3fs)", cmdDetails, err, output, seconds(duration))
-		os.Exit(1)
-	} else {
-		color.Green("PASS (%0.3fs)", seconds(duration))
+		return string(output), &GitError{Args: args, Output: string(output), Err: err}
 	}
+	return string(output), nil
 }
 
-func MustRunInteractiveCmd(name string, args ...string) {
+// Runs a command on the terminal.  Its output goes where gogitix's own messages do, which is stderr if stdout is being
+// used for events.
+func RunInteractiveCmd(name string, args ...string) error {
 	cmd := exec.Command(name, args...) // #nosec
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = color.Output
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 	if debug {
 		color.Magenta("[DEBUG] running '%s'", strings.Join(append([]string{name}, args...), " "))
 	}
-	if err := cmd.Run(); err != nil {
-		cmdName := strings.Join(append([]string{name}, args...), " ")
-		Failf(fmt.Sprintf("Command failed: %s\nError: %s\n\n", cmdName, err))
-	}
+	return cmd.Run()
 }
 
 func RunCmd(name string, args ...string) (string, error) {
+	return RunCmdInDir("", name, args...)
+}
+
+// Run a command in dir (or the current directory if dir is empty)
+func RunCmdInDir(dir string, name string, args ...string) (string, error) {
 	cmd := exec.Command(name, args...) // #nosec
+	cmd.Dir = dir
 	if debug {
 		color.Magenta("[DEBUG] running '%s'", strings.Join(append([]string{name}, args...), " "))
 	}
@@ -72,6 +71,11 @@ func RunCmd(name string, args ...string) (string, error) {
 	return string(output), err
 }
 
+// Wraps an error from running a command with its output
+func cmdError(err error, output string) error {
+	return fmt.Errorf("%s\nOutput: %s", err, output)
+}
+
 func seconds(duration time.Duration) float64 {
 	return float64(duration) / float64(time.Second)
 }
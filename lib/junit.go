@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []junitTestCase  `xml:"testcase"`
+	Suites    []junitTestSuite `xml:"testsuite"`
+	duration  float64
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Output  string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// Writes the results of check as JUnit XML.  Each command is a test case and each block of commands is a test suite.
+func WriteJUnitReport(w io.Writer, check Check, summary *Summary) error {
+	root := junitSuite(check, "", summary)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitTestSuite{root}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Path of a child of a block, matching the paths used by the parser
+func childPath(path string, parent Check, i int) string {
+	if many, ok := parent.(ManyChecks); ok && many.Parallel {
+		return fmt.Sprintf("%s/parallel/%d", path, i+1)
+	}
+	return fmt.Sprintf("%s/%d", path, i+1)
+}
+
+// Builds a suite for check.  Commands that aren't in a block get a suite of their own.
+func junitSuite(check Check, path string, summary *Summary) junitTestSuite {
+	suite := junitTestSuite{Name: "gogitix " + orRoot(path)}
+	if check.Name() == "" {
+		for i, childCheck := range check.Children() {
+			suite.add(childCheck, childPath(path, check, i), summary)
+		}
+	} else {
+		suite.add(check, path, summary)
+	}
+	suite.Time = fmt.Sprintf("%0.3f", suite.duration)
+	return suite
+}
+
+func (suite *junitTestSuite) add(check Check, path string, summary *Summary) {
+	name := check.Name()
+	if name == "" {
+		child := junitSuite(check, path, summary)
+		suite.Tests += child.Tests
+		suite.Failures += child.Failures
+		suite.Skipped += child.Skipped
+		suite.duration += child.duration
+		suite.Suites = append(suite.Suites, child)
+		return
+	}
+
+	result := summary.resultOrSkipped(name)
+	testCase := junitTestCase{
+		Name:      name,
+		ClassName: "gogitix" + strings.Replace(orRoot(path), "/", ".", -1),
+		Time:      fmt.Sprintf("%0.3f", seconds(result.Duration)),
+		SystemOut: string(result.Output),
+	}
+	switch result.Status {
+	case FAIL, TIMEOUT:
+		testCase.Failure = &junitFailure{Message: fmt.Sprint(result.Err), Type: result.Status.String(), Output: string(result.Output)}
+		suite.Failures++
+	case SKIPPED, CANCELED:
+		testCase.Skipped = &junitSkipped{Message: result.Status.String()}
+		suite.Skipped++
+	}
+	suite.Tests++
+	suite.duration += seconds(result.Duration)
+	suite.TestCases = append(suite.TestCases, testCase)
+}
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteJUnitReport(t *testing.T) {
+	check, err := parse(t, `[{parallel: [a, b]}, c]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	summary := NewSummary()
+	env := Env{Options: RunOptions{Summary: summary}}
+	env.Report("a", time.Now(), []byte("ok"), nil, false)
+	env.Report("b", time.Now(), []byte("bad & wrong"), errors.New("exit status 1"), false)
+
+	var report bytes.Buffer
+	assert.NoError(t, WriteJUnitReport(&report, check, summary))
+	assert.Contains(t, report.String(), `<testsuite name="gogitix /" tests="3" failures="1" skipped="1"`)
+	assert.Contains(t, report.String(), `<testsuite name="gogitix /1" tests="2" failures="1" skipped="0"`)
+	assert.Contains(t, report.String(), `<testcase name="b" classname="gogitix.1.parallel.2"`)
+	assert.Contains(t, report.String(), `<failure message="exit status 1" type="FAIL">bad &amp; wrong</failure>`)
+	assert.Contains(t, report.String(), `<testcase name="c" classname="gogitix.2" time="0.000">`)
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib/utils"
+)
+
+type Module struct {
+	Path            string   // Module path declared in go.mod
+	Dir             string   // Directory containing go.mod, relative to the workspace root ("." for the root module)
+	UpdatedDirs     []string // Directories owned by this module that have changed and still exist (sorted)
+	UpdatedPackages []string // Packages in this module that have changed and still exist (sorted)
+}
+
+// Returns the module path declared in the go.mod in dir, or "" if dir has no go.mod
+func findModulePath(dir string) (string, error) {
+	goModFile := filepath.Join(dir, "go.mod")
+	if _, err := os.Stat(goModFile); os.IsNotExist(err) {
+		return "", nil
+	}
+	return readModulePath(goModFile)
+}
+
+func readModulePath(goModFile string) (string, error) {
+	data, err := ioutil.ReadFile(goModFile)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"`"), nil
+		}
+	}
+	return "", fmt.Errorf("no module directive found in %s", goModFile)
+}
+
+// Find every module under rootDir, skipping the directories the go tool ignores
+func findModules(rootDir string) ([]Module, error) {
+	var modules []Module
+	err := filepath.Walk(rootDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if p != rootDir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() != "go.mod" {
+			return nil
+		}
+		modulePath, err := readModulePath(p)
+		if err != nil {
+			return err
+		}
+		dir, err := filepath.Rel(rootDir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		modules = append(modules, Module{Path: modulePath, Dir: filepath.ToSlash(dir)})
+		return nil
+	})
+	return modules, err
+}
+
+// Returns the index of the innermost module containing dir, or -1 if there is none
+func owningModule(modules []Module, dir string) int {
+	owner := -1
+	for i, m := range modules {
+		if m.Dir != "." && dir != m.Dir && !strings.HasPrefix(dir, m.Dir+"/") {
+			continue
+		}
+		if owner < 0 || len(m.Dir) > len(modules[owner].Dir) || modules[owner].Dir == "." {
+			owner = i
+		}
+	}
+	return owner
+}
+
+// Returns the packages of module m that are in one of the updated dirs
+func getUpdatedModulePackages(m Module, packages map[string][]string, updatedDirs []string) []string {
+	updatedDirMap := utils.StrMap(updatedDirs)
+
+	var updatedPackages []string
+	for p := range packages {
+		dirName := m.Dir
+		if p != m.Path {
+			dirName = path.Join(m.Dir, strings.TrimPrefix(p, m.Path+"/"))
+		}
+		if updatedDirMap[dirName] {
+			updatedPackages = append(updatedPackages, p)
+		}
+	}
+	return updatedPackages
+}
+
+// Configure the go tool to build the module checked out at rootDir
+func setupModuleEnv(rootDir string, modules []Module) error {
+	if err := os.Setenv("GO111MODULE", "on"); err != nil {
+		return err
+	}
+
+	// Only use a workspace file if one was checked out with the module, so that one from the user's environment
+	// doesn't leak in
+	goWork := "off"
+	if _, err := os.Stat(filepath.Join(rootDir, "go.work")); err == nil {
+		goWork = filepath.Join(rootDir, "go.work")
+	}
+	if err := os.Setenv("GOWORK", goWork); err != nil {
+		return err
+	}
+
+	// A single -mod=vendor can't apply to every module in a multi-module repo, so leave vendoring to the go tool there
+	if len(modules) > 1 {
+		return nil
+	}
+
+	goFlags := os.Getenv("GOFLAGS")
+	if _, err := os.Stat(filepath.Join(rootDir, "vendor", "modules.txt")); err == nil && !strings.Contains(goFlags, "-mod=") {
+		return os.Setenv("GOFLAGS", strings.TrimSpace(goFlags+" -mod=vendor"))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwningModule(t *testing.T) {
+	modules := []Module{
+		{Path: "example.com/root", Dir: "."},
+		{Path: "example.com/root/a", Dir: "a"},
+		{Path: "example.com/root/a/b", Dir: "a/b"},
+	}
+
+	specs := []struct {
+		dir           string
+		expectedOwner int
+	}{
+		{".", 0},
+		{"c", 0},
+		{"a", 1},
+		{"ab", 0},
+		{"a/c", 1},
+		{"a/b", 2},
+		{"a/b/c", 2},
+	}
+
+	for i, spec := range specs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			assert.Equal(t, spec.expectedOwner, owningModule(modules, spec.dir))
+		})
+	}
+
+	assert.Equal(t, -1, owningModule(modules[1:], "c"))
+}
+
+// Makes a temporary directory with the given files in it, removed when the test finishes
+func writeTree(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "gogitix-modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for file, contents := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(contents), 0644))
+	}
+	return dir
+}
+
+func TestReadModulePath(t *testing.T) {
+	specs := []struct {
+		goMod        string
+		expectedPath string
+		expectedErr  bool
+	}{
+		{"module example.com/a\n", "example.com/a", false},
+		{"// A comment\nmodule example.com/a // another\n\ngo 1.21\n", "example.com/a", false},
+		{"module \"example.com/quoted\"\n", "example.com/quoted", false},
+		{"module `example.com/raw`\n", "example.com/raw", false},
+		{"go 1.21\nrequire example.com/b v1.0.0\n", "", true},
+		{"// module example.com/commented\n", "", true},
+	}
+
+	for i, spec := range specs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"go.mod": spec.goMod})
+			modulePath, err := readModulePath(filepath.Join(dir, "go.mod"))
+			if spec.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, spec.expectedPath, modulePath)
+
+			modulePath, err = findModulePath(dir)
+			assert.Equal(t, spec.expectedPath, modulePath)
+			assert.Equal(t, spec.expectedErr, err != nil)
+		})
+	}
+
+	modulePath, err := findModulePath(writeTree(t, map[string]string{"a.go": "package a"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "", modulePath, "there's no module without a go.mod")
+}
+
+func TestFindModules(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"go.mod":                "module example.com/root",
+		"a/go.mod":              "module example.com/root/a",
+		"a/b/go.mod":            "module example.com/b",
+		"c/c.go":                "package c",
+		"vendor/x/go.mod":       "module example.com/x",
+		"testdata/go.mod":       "module example.com/testdata",
+		"_hidden/go.mod":        "module example.com/hidden",
+		".dot/go.mod":           "module example.com/dot",
+		"a/testdata/mod/go.mod": "module example.com/a/testdata",
+	})
+
+	modules, err := findModules(dir)
+	assert.NoError(t, err)
+	// In the order of the walk, which visits the files in each directory in lexical order
+	assert.Equal(t, []Module{
+		{Path: "example.com/b", Dir: "a/b"},
+		{Path: "example.com/root/a", Dir: "a"},
+		{Path: "example.com/root", Dir: "."},
+	}, modules)
+
+	_, err = findModules(writeTree(t, map[string]string{"go.mod": "go 1.21"}))
+	assert.Error(t, err, "a go.mod without a module directive")
+}
+
+func TestSetupModuleEnv(t *testing.T) {
+	specs := []struct {
+		files           map[string]string
+		modules         int
+		goFlags         string
+		expectedGoWork  string
+		expectedGoFlags string
+	}{
+		{map[string]string{}, 1, "", "off", ""},
+		{map[string]string{"go.work": "go 1.21"}, 1, "", "go.work", ""},
+		{map[string]string{"vendor/modules.txt": ""}, 1, "", "off", "-mod=vendor"},
+		{map[string]string{"vendor/modules.txt": ""}, 1, "-v", "off", "-v -mod=vendor"},
+		{map[string]string{"vendor/modules.txt": ""}, 1, "-mod=mod", "off", "-mod=mod"},
+		{map[string]string{"vendor/modules.txt": ""}, 2, "", "off", ""},
+		{map[string]string{"vendor/x/x.go": ""}, 1, "", "off", ""},
+	}
+
+	for i, spec := range specs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("GO111MODULE", "off")
+			t.Setenv("GOWORK", "")
+			t.Setenv("GOFLAGS", spec.goFlags)
+
+			dir := writeTree(t, spec.files)
+			modules := make([]Module, spec.modules)
+			assert.NoError(t, setupModuleEnv(dir, modules))
+			assert.Equal(t, "on", os.Getenv("GO111MODULE"))
+			expectedGoWork := spec.expectedGoWork
+			if expectedGoWork != "off" {
+				expectedGoWork = filepath.Join(dir, expectedGoWork)
+			}
+			assert.Equal(t, expectedGoWork, os.Getenv("GOWORK"))
+			assert.Equal(t, spec.expectedGoFlags, os.Getenv("GOFLAGS"))
+		})
+	}
+}
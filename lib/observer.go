@@ -0,0 +1,94 @@
+package lib
+
+import "bytes"
+
+// Observer is told about the progress of a run.  Methods may be called concurrently by commands running in parallel.
+type Observer interface {
+	OnWorkspaceReady(ws Workspace)
+	OnCheckStart(cmd Command)
+	OnOutput(cmd Command, line string) // Called for each line of output as the command runs
+	OnCheckEnd(cmd Command, result Result)
+	OnReformat(action string, files []string)
+	OnRunEnd(check Check, summary *Summary, err error)
+}
+
+// Observers passes everything it is told on to each of its observers in turn
+type Observers []Observer
+
+func (o Observers) OnWorkspaceReady(ws Workspace) {
+	for _, observer := range o {
+		observer.OnWorkspaceReady(ws)
+	}
+}
+
+func (o Observers) OnCheckStart(cmd Command) {
+	for _, observer := range o {
+		observer.OnCheckStart(cmd)
+	}
+}
+
+func (o Observers) OnOutput(cmd Command, line string) {
+	for _, observer := range o {
+		observer.OnOutput(cmd, line)
+	}
+}
+
+func (o Observers) OnCheckEnd(cmd Command, result Result) {
+	for _, observer := range o {
+		observer.OnCheckEnd(cmd, result)
+	}
+}
+
+func (o Observers) OnReformat(action string, files []string) {
+	for _, observer := range o {
+		observer.OnReformat(action, files)
+	}
+}
+
+func (o Observers) OnRunEnd(check Check, summary *Summary, err error) {
+	for _, observer := range o {
+		observer.OnRunEnd(check, summary, err)
+	}
+}
+
+// Returns observer, or the console if there isn't one
+func observerOrConsole(observer Observer, dryRun bool) Observer {
+	if observer == nil {
+		return ConsoleObserver{DryRun: dryRun}
+	}
+	return observer
+}
+
+// Returns a writer that passes each line written to it to observer as output of cmd
+func outputWriter(observer Observer, cmd Command) *lineWriter {
+	return &lineWriter{onLine: func(line string) {
+		observer.OnOutput(cmd, line)
+	}}
+}
+
+// Calls onLine for each complete line written
+type lineWriter struct {
+	partial []byte
+	onLine  func(line string)
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.partial = append(w.partial, data...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(data), nil
+}
+
+// Sends any final line that wasn't terminated by a newline
+func (w *lineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.onLine(string(w.partial))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingObserver struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (o *recordingObserver) record(format string, args ...interface{}) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.events = append(o.events, fmt.Sprintf(format, args...))
+}
+
+func (o *recordingObserver) OnWorkspaceReady(ws Workspace) {
+	o.record("ready")
+}
+
+func (o *recordingObserver) OnCheckStart(cmd Command) {
+	o.record("start %s %s", cmd.Name, cmd.Timeout)
+}
+
+func (o *recordingObserver) OnOutput(cmd Command, line string) {
+	o.record("output %s %s", cmd.Name, line)
+}
+
+func (o *recordingObserver) OnCheckEnd(cmd Command, result Result) {
+	o.record("end %s %s", cmd.Name, result.Status)
+}
+
+func (o *recordingObserver) OnReformat(action string, files []string) {
+	o.record("reformat %s", action)
+}
+
+func (o *recordingObserver) OnRunEnd(check Check, summary *Summary, err error) {
+	o.record("run end %v", err)
+}
+
+func TestExecutorNotifiesObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	executor := CommandExecutor{Timeout: time.Minute, Observer: Observers{observer}}
+
+	output, err := executor.ExecuteWithOutput(context.Background(), Workspace{}, Command{Name: "a", Command: "echo one; printf two; exit 3"})
+	assert.Equal(t, "one\ntwo", string(output))
+	if assert.IsType(t, &CommandFailedError{}, err) {
+		assert.Equal(t, 3, err.(*CommandFailedError).ExitCode)
+	}
+	assert.Equal(t, []string{"start a 1m0s", "output a one", "output a two", "end a FAIL"}, observer.events)
+}
+
+func TestRunCheckNotifiesObserver(t *testing.T) {
+	check, err := parse(t, `[{run: {name: a, command: "true"}}, {run: {name: b, command: exit 2}}]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	observer := &recordingObserver{}
+	errs := make(chan error, 2)
+	RunCheck(context.Background(), Workspace{}, CommandExecutor{Observer: observer}, check, RunOptions{Observer: observer}, errs)
+	assert.Equal(t, []string{"ready", "start a 0s", "end a PASS", "start b 0s", "end b FAIL", "run end b failed: exit status 2"},
+		observer.events)
+}
@@ -3,6 +3,7 @@ package lib
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v2"
 )
@@ -11,26 +12,67 @@ type Parser struct {
 	nextNumberForName map[string]int
 }
 
+// CheckParser makes a check from the value of a key registered with RegisterCheckType.  It can use p to parse checks
+// nested in value and to give the check a unique name.
+type CheckParser func(p Parser, value interface{}, path string) (Check, error)
+
+var checkTypesLock sync.Mutex
+var checkTypes = map[string]CheckParser{}
+
+// Registers a kind of check that the parser makes from objects with key as their only key, e.g. `analyze: {...}`.
+// This panics if key is already in use.
+func RegisterCheckType(key string, parse CheckParser) {
+	checkTypesLock.Lock()
+	defer checkTypesLock.Unlock()
+	if key == "run" || key == "parallel" || key == "reformat" || checkTypes[key] != nil {
+		panic(fmt.Sprintf("check type '%s' is already registered", key))
+	}
+	checkTypes[key] = parse
+}
+
+func registeredCheckType(key interface{}) CheckParser {
+	name, ok := key.(string)
+	if !ok {
+		return nil
+	}
+	checkTypesLock.Lock()
+	defer checkTypesLock.Unlock()
+	return checkTypes[name]
+}
+
 func NewParser() Parser {
 	return Parser{
 		nextNumberForName: map[string]int{},
 	}
 }
 
+// Parses a check at path in the config.  Parsing the whole config (at path "") also makes sure that the steps named in
+// 'needs' exist and don't depend on each other in a cycle.
 func (p Parser) Parse(check interface{}, path string) (Check, error) {
+	parsedCheck, err := p.parse(check, path)
+	if err != nil || path != "" {
+		return parsedCheck, err
+	}
+	if err := validateNeeds(parsedCheck); err != nil {
+		return nil, err
+	}
+	return parsedCheck, nil
+}
+
+func (p Parser) parse(check interface{}, path string) (Check, error) {
 	switch check := check.(type) {
 	case map[interface{}]interface{}: // Object
 		if reformat, isReformat := check["reformat"].(map[interface{}]interface{}); isReformat {
 			var reformatCheck, reformatCommand SingleCheck
 			var ok bool
 
-			if reformatCheckRaw, err := p.Parse(reformat["check"], path+"/check"); err != nil {
+			if reformatCheckRaw, err := p.parse(reformat["check"], path+"/check"); err != nil {
 				return nil, fmt.Errorf("could not parse reformat 'check' at %s: %s", orRoot(path), err)
 			} else if reformatCheck, ok = reformatCheckRaw.(SingleCheck); !ok {
 				return nil, fmt.Errorf("expected simple command for reformat 'check' at %s", orRoot(path))
 			}
 
-			if reformatCommandRaw, err := p.Parse(reformat["format"], path+"/format"); err != nil {
+			if reformatCommandRaw, err := p.parse(reformat["format"], path+"/format"); err != nil {
 				return nil, fmt.Errorf("could not parse reformat 'format' at %s: %s", orRoot(path), err)
 			} else if reformatCommand, ok = reformatCommandRaw.(SingleCheck); !ok {
 				return nil, fmt.Errorf("expected simple command for reformat 'format' at %s", orRoot(path))
@@ -44,21 +86,40 @@ func (p Parser) Parse(check interface{}, path string) (Check, error) {
 			return nil, fmt.Errorf("reformat must be key for an object at %s", orRoot(path))
 		}
 
-		if check["parallel"] != nil && len(check) > 1 {
-			return nil, fmt.Errorf("'parallel' must be the only key at %s", orRoot(path))
+		maxParallel, hasMaxParallel := check["max_parallel"]
+		if hasMaxParallel {
+			if _, found := check["parallel"]; !found {
+				return nil, fmt.Errorf("'max_parallel' can only be used with 'parallel' at %s", orRoot(path))
+			}
+			if n, ok := maxParallel.(int); !ok || n < 1 {
+				return nil, fmt.Errorf("'max_parallel' must be a positive number at %s", orRoot(path))
+			}
+		}
+
+		if check["parallel"] != nil && len(check) > 1 && !(len(check) == 2 && hasMaxParallel) {
+			return nil, fmt.Errorf("'parallel' must be the only key (other than 'max_parallel') at %s", orRoot(path))
 		}
 
 		if check["run"] != nil && len(check) > 1 {
 			return nil, fmt.Errorf("'run' must be the only key at %s", orRoot(path))
 		}
 
+		for key, value := range check {
+			if parse := registeredCheckType(key); parse != nil {
+				if len(check) > 1 {
+					return nil, fmt.Errorf("'%s' must be the only key at %s", key, orRoot(path))
+				}
+				return parse(p, value, fmt.Sprintf("%s/%s", path, key))
+			}
+		}
+
 		switch checkParallel, found := check["parallel"]; checkParallel := checkParallel.(type) {
 		case nil: // ignore
 			if found {
-				return p.parseCheckArray([]interface{}{}, path+"/parallel", true)
+				return p.parseParallelArray([]interface{}{}, path+"/parallel", maxParallel)
 			}
 		case []interface{}:
-			return p.parseCheckArray(checkParallel, path+"/parallel", true)
+			return p.parseParallelArray(checkParallel, path+"/parallel", maxParallel)
 		default:
 			return nil, fmt.Errorf("value for key 'parallel' must be an array")
 		}
@@ -69,19 +130,33 @@ func (p Parser) Parse(check interface{}, path string) (Check, error) {
 			// Remarshal the check into a command object
 			if data, err := yaml.Marshal(check); err != nil {
 				return nil, fmt.Errorf("unable to parse command at %s: %s", orRoot(path), err)
-			} else {
-				yaml.Unmarshal(data, &cmd)
+			} else if err := yaml.Unmarshal(data, &cmd); err != nil {
+				return nil, fmt.Errorf("unable to parse command at %s: %s", orRoot(path), err)
 			}
 		case string:
 			cmd.Command = checkRun
 		case []interface{}:
 			return p.parseCheckArray(checkRun, path, false)
 		case map[interface{}]interface{}:
-			return p.Parse(checkRun, path+"/run")
+			return p.parse(checkRun, path+"/run")
 		default:
 			return nil, fmt.Errorf("unexpected type for 'run' at %s: %v", orRoot(path), checkRun)
 		}
 
+		if cmd.Timeout < 0 {
+			return nil, fmt.Errorf("timeout must not be negative at %s", orRoot(path))
+		}
+
+		if cmd.Diagnostics != "" {
+			if _, err := compileDiagnosticRegexp(cmd.Diagnostics); err != nil {
+				return nil, fmt.Errorf("invalid diagnostics expression at %s: %s", orRoot(path), err)
+			}
+		}
+
+		if cmd.Filter != "" && cmd.Filter != FilterChangedLines {
+			return nil, fmt.Errorf("unknown filter '%s' at %s", cmd.Filter, orRoot(path))
+		}
+
 		cmd.Name = p.makeNumberedName(cmd.Name, cmd.Command)
 
 		return SingleCheck{
@@ -101,7 +176,7 @@ func (p Parser) Parse(check interface{}, path string) (Check, error) {
 		childChecks := make([]Check, len(check))
 		for i, c := range check {
 			var err error
-			if childChecks[i], err = p.Parse(c, path+fmt.Sprintf("/%d", i+1)); err != nil {
+			if childChecks[i], err = p.parse(c, path+fmt.Sprintf("/%d", i+1)); err != nil {
 				return nil, err
 			}
 		}
@@ -113,6 +188,11 @@ func (p Parser) Parse(check interface{}, path string) (Check, error) {
 	}
 }
 
+// Returns name, numbered if it has already been used so that every check has a different name
+func (p Parser) UniqueName(name string) string {
+	return p.makeNumberedName(name, "")
+}
+
 func (p Parser) makeNumberedName(name string, cmd string) string {
 	if name == "" {
 		if strings.TrimSpace(cmd) == "" {
@@ -131,7 +211,7 @@ func (p Parser) makeNumberedName(name string, cmd string) string {
 }
 
 func (p Parser) parseCheckArray(checkArray []interface{}, path string, parallel bool) (Check, error) {
-	if childChecksIf, err := p.Parse(checkArray, path); err != nil {
+	if childChecksIf, err := p.parse(checkArray, path); err != nil {
 		return nil, err
 	} else {
 		childChecks := childChecksIf.(ManyChecks)
@@ -140,6 +220,16 @@ func (p Parser) parseCheckArray(checkArray []interface{}, path string, parallel
 	}
 }
 
+func (p Parser) parseParallelArray(checkArray []interface{}, path string, maxParallel interface{}) (Check, error) {
+	check, err := p.parseCheckArray(checkArray, path, true)
+	if err != nil {
+		return nil, err
+	}
+	childChecks := check.(ManyChecks)
+	childChecks.MaxParallel, _ = maxParallel.(int)
+	return childChecks, nil
+}
+
 func orRoot(str string) string {
 	if str == "" {
 		return "/"
@@ -1,8 +1,11 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -37,9 +40,22 @@ func TestParseCheck(t *testing.T) {
 			},
 			Parallel: false,
 		}, ""},
+		{"run: {command: go vet, filter: changed-lines}", SingleCheck{Command: Command{Name: "go", Command: "go vet", Filter: "changed-lines"}}, ""},
+		{"run: {command: go vet, filter: nope}", nil, "unknown filter 'nope' at /run"},
+		{"run: {command: go test, timeout: 90s}", SingleCheck{Command: Command{Name: "go", Command: "go test", Timeout: 90 * time.Second}}, ""},
+		{`run: {command: lint, diagnostics: "(?P<file>[^(]+)\\((?P<line>\\d+)\\)"}`, SingleCheck{Command: Command{Name: "lint", Command: "lint", Diagnostics: `(?P<file>[^(]+)\((?P<line>\d+)\)`}}, ""},
+		{`run: {command: lint, diagnostics: "(.*):(\\d+)"}`, nil, "invalid diagnostics expression at /run: expression must have 'file' and 'line' groups, e.g. (?P<file>...)"},
 		{"run: []", ManyChecks{Checks: []Check{}, Parallel: false}, ""},
 		{"run:", SingleCheck{Command: Command{Name: "<empty command>"}}, ""},
 		{"parallel:", ManyChecks{Checks: []Check{}, Parallel: true}, ""},
+		{`{parallel: [a], max_parallel: 2}`, ManyChecks{
+			Checks:      []Check{SingleCheck{Command: Command{Name: "a", Command: "a"}}},
+			Parallel:    true,
+			MaxParallel: 2,
+		}, ""},
+		{`{parallel: [a], max_parallel: 0}`, nil, "'max_parallel' must be a positive number at /"},
+		{`{run: [a], max_parallel: 2}`, nil, "'max_parallel' can only be used with 'parallel' at /"},
+		{`{parallel: [a], run: b}`, nil, "'parallel' must be the only key (other than 'max_parallel') at /"},
 		{`parallel: [a, b]`, ManyChecks{
 			Checks: []Check{
 				SingleCheck{Command: Command{Name: "a", Command: "a"}},
@@ -61,3 +77,105 @@ func TestParseCheck(t *testing.T) {
 	}
 
 }
+
+type countCheck struct {
+	name  string
+	count int
+}
+
+func (check countCheck) Name() string {
+	return check.name
+}
+
+func (countCheck) Children() []Check {
+	return nil
+}
+
+func (check countCheck) Run(ctx context.Context, env Env) Result {
+	return env.Report(check.name, time.Now(), []byte(fmt.Sprintf("counted to %d", check.count)), nil, false)
+}
+
+func TestParseRegisteredCheckType(t *testing.T) {
+	RegisterCheckType("count", func(p Parser, value interface{}, path string) (Check, error) {
+		count, ok := value.(int)
+		if !ok {
+			return nil, fmt.Errorf("expected a number at %s", path)
+		}
+		return countCheck{name: p.UniqueName("count"), count: count}, nil
+	})
+	defer func() {
+		checkTypesLock.Lock()
+		delete(checkTypes, "count")
+		checkTypesLock.Unlock()
+	}()
+
+	assert.Panics(t, func() { RegisterCheckType("run", nil) })
+
+	_, err := parse(t, `[{count: 3, name: x}]`)
+	assert.EqualError(t, err, "'count' must be the only key at /1")
+	_, err = parse(t, `[{count: many}]`)
+	assert.EqualError(t, err, "expected a number at /1/count")
+
+	check, err := parse(t, `[ls, {parallel: [{count: 3}, {count: 5}]}]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, ManyChecks{Checks: []Check{
+		SingleCheck{Command: Command{Name: "ls", Command: "ls"}},
+		ManyChecks{Checks: []Check{countCheck{name: "count", count: 3}, countCheck{name: "count:2", count: 5}}, Parallel: true},
+	}}, check)
+
+	summary := NewSummary()
+	errs := make(chan error, 3)
+	RunCheck(context.Background(), Workspace{}, CommandExecutor{DryRun: true, Observer: Observers{}}, check, RunOptions{Summary: summary, Observer: Observers{}}, errs)
+	var results []string
+	for _, result := range summary.Results(check) {
+		results = append(results, fmt.Sprintf("%s=%s %s", result.Name, result.Status, result.Output))
+	}
+	assert.Equal(t, []string{"ls=PASS ", "count=PASS counted to 3", "count:2=PASS counted to 5"}, results)
+}
+
+// A check that only returns its result, without reporting it
+type returningCheck struct {
+	name string
+	err  error
+}
+
+func (check returningCheck) Name() string {
+	return check.name
+}
+
+func (returningCheck) Children() []Check {
+	return nil
+}
+
+func (check returningCheck) Run(ctx context.Context, env Env) Result {
+	return Result{Status: FAIL, Err: check.err}
+}
+
+func TestRunCheckReportsReturnedResult(t *testing.T) {
+	broken := errors.New("broken")
+	check := ManyChecks{Checks: []Check{
+		returningCheck{name: "a", err: broken},
+		SingleCheck{Command: Command{Name: "b", Command: "b", Needs: []string{"a"}}},
+	}, Parallel: true}
+
+	summary := NewSummary()
+	errs := make(chan error, 3)
+	RunCheck(context.Background(), Workspace{}, CommandExecutor{DryRun: true, Observer: Observers{}}, check, RunOptions{Summary: summary, Observer: Observers{}}, errs)
+	var sent []error
+	for err := range errs {
+		sent = append(sent, err)
+	}
+	assert.Equal(t, broken, RunError(sent))
+	var results []string
+	for _, result := range summary.Results(check) {
+		results = append(results, fmt.Sprintf("%s=%s", result.Name, result.Status))
+	}
+	assert.Equal(t, []string{"a=FAIL", "b=SKIPPED"}, results)
+
+	// A block that fails without a command failing still fails the run
+	errs = make(chan error, 1)
+	RunCheck(context.Background(), Workspace{}, CommandExecutor{DryRun: true, Observer: Observers{}}, returningCheck{err: broken}, RunOptions{Observer: Observers{}}, errs)
+	assert.Equal(t, broken, <-errs)
+}
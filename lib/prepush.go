@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PushedRef is a ref that git is about to push, as passed to a pre-push hook
+type PushedRef struct {
+	LocalRef  string
+	LocalSha  string
+	RemoteRef string
+	RemoteSha string
+}
+
+// Reads the "<local ref> <local sha> <remote ref> <remote sha>" lines git passes to a pre-push hook on stdin
+func ParsePushedRefs(r io.Reader) ([]PushedRef, error) {
+	var refs []PushedRef
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected pre-push line: %s", scanner.Text())
+		}
+		refs = append(refs, PushedRef{LocalRef: fields[0], LocalSha: fields[1], RemoteRef: fields[2], RemoteSha: fields[3]})
+	}
+	return refs, scanner.Err()
+}
+
+// Whether sha is the null sha git uses for refs that don't exist
+func isNullSha(sha string) bool {
+	return strings.Trim(sha, "0") == ""
+}
+
+// Whether the push deletes the remote ref
+func (ref PushedRef) Deleted() bool {
+	return isNullSha(ref.LocalSha)
+}
+
+// Returns the revision range of the commits being pushed to ref, or "" if nothing new is being pushed.  For a ref that
+// is new to the remote (or whose remote commit we don't have), the range starts where the local commit branched off
+// the default branch of the remote.
+func (ref PushedRef) Range(gitRoot string, remote string) (string, error) {
+	if ref.Deleted() {
+		return "", nil
+	}
+
+	base := ref.RemoteSha
+	if isNullSha(base) || !commitExists(gitRoot, base) {
+		defaultBranch, err := remoteDefaultBranch(gitRoot, remote)
+		if err != nil {
+			return "", err
+		}
+		if defaultBranch == "" {
+			// There's nothing to compare to, so just check the commit being pushed
+			return ref.LocalSha + "^!", nil
+		}
+		output, err := RunGit(gitRoot, "merge-base", defaultBranch, ref.LocalSha)
+		if err != nil {
+			return ref.LocalSha + "^!", nil // Unrelated histories
+		}
+		base = strings.TrimSpace(output)
+	}
+
+	if base == ref.LocalSha {
+		return "", nil
+	}
+	return base + ".." + ref.LocalSha, nil
+}
+
+func commitExists(gitRoot string, sha string) bool {
+	_, err := RunGit(gitRoot, "cat-file", "-e", sha+"^{commit}")
+	return err == nil
+}
+
+// Returns the remote-tracking ref of the default branch of remote, or "" if it isn't known
+func remoteDefaultBranch(gitRoot string, remote string) (string, error) {
+	if remote == "" {
+		return "", nil
+	}
+	if output, err := RunGit(gitRoot, "symbolic-ref", "-q", "refs/remotes/"+remote+"/HEAD"); err == nil {
+		return strings.TrimSpace(output), nil
+	}
+	for _, branch := range []string{"main", "master"} {
+		ref := "refs/remotes/" + remote + "/" + branch
+		if _, err := RunGit(gitRoot, "rev-parse", "-q", "--verify", ref); err == nil {
+			return ref, nil
+		}
+	}
+	return "", nil
+}
+
+// UnionRangeError is returned by UnionRange when the ranges can't be combined into one
+type UnionRangeError struct {
+	Reason string
+}
+
+func (e *UnionRangeError) Error() string {
+	return "unable to check the refs together because " + e.Reason
+}
+
+// Combines ranges of the form "<base>..<tip>" or "<tip>^!" into one range, which is only possible if one of the tips
+// contains all of the others and each range has a base.  The combined range starts from the common ancestor of the
+// bases.  Returns a UnionRangeError if the ranges can't be combined.
+func UnionRange(gitRoot string, ranges []string) (string, error) {
+	var bases, tips []string
+	for _, r := range ranges {
+		if strings.HasSuffix(r, "^!") {
+			tip := strings.TrimSuffix(r, "^!")
+			if !commitExists(gitRoot, tip+"^") {
+				return "", &UnionRangeError{Reason: fmt.Sprintf("%s has no parent to start from", tip)}
+			}
+			bases = append(bases, tip+"^")
+			tips = append(tips, tip)
+		} else if parts := strings.SplitN(r, "..", 2); len(parts) == 2 {
+			bases = append(bases, parts[0])
+			tips = append(tips, parts[1])
+		} else {
+			return "", fmt.Errorf("unexpected revision range '%s'", r)
+		}
+	}
+	if len(tips) == 0 {
+		return "", nil
+	}
+
+	// Find the tip that contains the others
+	tip := tips[0]
+	for _, other := range tips[1:] {
+		if _, err := RunGit(gitRoot, "merge-base", "--is-ancestor", other, tip); err == nil {
+			continue
+		}
+		if _, err := RunGit(gitRoot, "merge-base", "--is-ancestor", tip, other); err == nil {
+			tip = other
+			continue
+		}
+		return "", &UnionRangeError{Reason: fmt.Sprintf("neither %s nor %s contains the other", tip, other)}
+	}
+	for _, other := range tips {
+		if _, err := RunGit(gitRoot, "merge-base", "--is-ancestor", other, tip); err != nil {
+			return "", &UnionRangeError{Reason: fmt.Sprintf("neither %s nor %s contains the other", tip, other)}
+		}
+	}
+
+	base := bases[0]
+	if len(bases) > 1 {
+		output, err := RunGit(gitRoot, append([]string{"merge-base", "--octopus"}, bases...)...)
+		if err != nil {
+			return "", err
+		}
+		base = strings.TrimSpace(output)
+	}
+	return base + ".." + tip, nil
+}
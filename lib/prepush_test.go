@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nullSha = "0000000000000000000000000000000000000000"
+
+func TestParsePushedRefs(t *testing.T) {
+	refs, err := ParsePushedRefs(strings.NewReader("refs/heads/a 111 refs/heads/a 222\n\nrefs/heads/b 333 refs/heads/b " + nullSha + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []PushedRef{
+		{LocalRef: "refs/heads/a", LocalSha: "111", RemoteRef: "refs/heads/a", RemoteSha: "222"},
+		{LocalRef: "refs/heads/b", LocalSha: "333", RemoteRef: "refs/heads/b", RemoteSha: nullSha},
+	}, refs)
+
+	_, err = ParsePushedRefs(strings.NewReader("refs/heads/a 111\n"))
+	assert.EqualError(t, err, "unexpected pre-push line: refs/heads/a 111")
+}
+
+func TestPushedRefRange(t *testing.T) {
+	repo := newTestRepo(t)
+	gitRoot := repo.Root
+	first := repo.commit("first")
+	second := repo.commit("second")
+	repo.git("update-ref", "refs/remotes/origin/main", second)
+	third := repo.commit("third")
+	fourth := repo.commit("fourth")
+
+	rangeOf := func(ref PushedRef, remote string) string {
+		r, err := ref.Range(gitRoot, remote)
+		assert.NoError(t, err)
+		return r
+	}
+	assert.Equal(t, second+".."+fourth, rangeOf(PushedRef{LocalSha: fourth, RemoteSha: second}, "origin"))
+	assert.Equal(t, "", rangeOf(PushedRef{LocalSha: second, RemoteSha: second}, "origin"), "nothing new")
+	assert.Equal(t, "", rangeOf(PushedRef{LocalSha: nullSha, RemoteSha: second}, "origin"), "deleted")
+	assert.Equal(t, second+".."+fourth, rangeOf(PushedRef{LocalSha: fourth, RemoteSha: nullSha}, "origin"),
+		"new branches start from the default branch")
+	assert.Equal(t, second+".."+fourth, rangeOf(PushedRef{LocalSha: fourth, RemoteSha: strings.Repeat("1", 40)}, "origin"),
+		"unknown remote commits are treated like new branches")
+	assert.Equal(t, fourth+"^!", rangeOf(PushedRef{LocalSha: fourth, RemoteSha: nullSha}, "elsewhere"),
+		"without a default branch, only the pushed commit is checked")
+
+	union, err := UnionRange(gitRoot, []string{second + ".." + third, first + ".." + fourth, fourth + "^!"})
+	assert.NoError(t, err)
+	assert.Equal(t, first+".."+fourth, union)
+
+	repo.git("checkout", "-q", "-b", "other", second)
+	other := repo.commit("other")
+	_, err = UnionRange(gitRoot, []string{second + ".." + fourth, second + ".." + other})
+	assert.IsType(t, &UnionRangeError{}, err, "diverged tips can't be checked together")
+
+	_, err = UnionRange(gitRoot, []string{first + "^!", second + ".." + fourth})
+	assert.IsType(t, &UnionRangeError{}, err, "a root commit has no base")
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// How long a canceled command has to exit after being asked to terminate before it is killed
+var killGracePeriod = 2 * time.Second
+
+// Runs cmd in its own process group, returning its combined output.  If ctx is done before the command finishes, the
+// whole group is stopped and whatever output was captured so far is returned along with the context's error.  Output is
+// also copied to tee as it is produced, if it is not nil.
+func runInProcessGroup(ctx context.Context, cmd *exec.Cmd, tee io.Writer) ([]byte, error) {
+	var output bytes.Buffer
+	var outputWriter io.Writer = &output
+	if tee != nil {
+		outputWriter = io.MultiWriter(&output, tee)
+	}
+	cmd.Stdout = outputWriter
+	cmd.Stderr = outputWriter
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-ctx.Done():
+	}
+
+	terminateProcessGroup(cmd)
+	select {
+	case <-done:
+	case <-time.After(killGracePeriod):
+		killProcessGroup(cmd)
+		<-done
+	}
+	// Make sure nothing that ignored the request to terminate is left behind
+	killProcessGroup(cmd)
+	return output.Bytes(), ctx.Err()
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package lib
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Start the command in its own process group so we can stop everything it spawns
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) {
+	// A negative pid signals every process in the group
+	syscall.Kill(-cmd.Process.Pid, sig) // #nosec
+}
+
+func terminateProcessGroup(cmd *exec.Cmd) {
+	signalProcessGroup(cmd, syscall.SIGTERM)
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+// Whether a process with the pid is still running
+func processExists(pid int) bool {
+	// Signal 0 only checks whether the process could be signaled
+	err := syscall.Kill(pid, 0) // #nosec
+	return err == nil || err == syscall.EPERM
+}
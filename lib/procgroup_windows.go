@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package lib
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Process groups aren't available, so we can only stop the command itself
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill() // #nosec
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill() // #nosec
+}
+
+// Whether a process with the pid is still running
+func processExists(pid int) bool {
+	// Finding a process opens it, which fails if it has exited
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release() // #nosec
+	return true
+}
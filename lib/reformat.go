@@ -1,46 +1,57 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
-	"github.com/fatih/color"
-
 	"gopkg.in/launchdarkly/gogitix.v2/lib/utils"
 )
 
-func Reformat(ws Workspace, executor Executor, check ReformatCheck, staging bool, skipReformat bool) error {
+// Actions reported to observers as reformatting progresses
+const (
+	ReformatNeedsFormatting      = "needs_formatting"
+	ReformatSkippedUnstaged      = "skipped_unstaged"
+	ReformatReformatting         = "reformatting"
+	ReformatWaitingForReview     = "waiting_for_review"
+	ReformatStaged               = "staged"
+	ReformatStillNeedsFormatting = "still_needs_formatting"
+	ReformatNotNeeded            = "not_needed"
+)
+
+func Reformat(ctx context.Context, ws Workspace, executor Executor, check ReformatCheck, opts RunOptions) error {
+	staging := opts.Staging
+	observer := observerOrConsole(opts.Observer, false)
 	if len(ws.UpdatedFiles) > 0 {
 		checkCommand := check.Check.Command
 		if checkCommand.Description != "" {
 			checkCommand.Description = fmt.Sprintf("Checking formatting ... (%d file(s) changed)", len(ws.UpdatedFiles))
 		}
-		output, err := executor.ExecuteWithOutput(ws, checkCommand)
+		output, err := executor.ExecuteWithOutput(ctx, ws, checkCommand)
 		if err != nil {
-			return fmt.Errorf("reformat check command failed: %s", err)
+			return err
 		}
 		needsFormatting := string(output)
 		if needsFormatting != "" {
 			files := strings.Fields(needsFormatting)
+			observer.OnReformat(ReformatNeedsFormatting, files)
 			filesToUpdate := []string{}
 			filesWithUnstagedChanges := utils.StrMap(ws.LocallyChangedFiles)
 			for _, file := range files {
 				if staging && filesWithUnstagedChanges[file] {
-					color.Red("Did not automatically reformat '%s' because it has un-staged changes.", file)
+					observer.OnReformat(ReformatSkippedUnstaged, []string{file})
 				} else {
 					filesToUpdate = append(filesToUpdate, file)
 				}
 			}
 
-			if len(filesToUpdate) > 0 && !skipReformat {
+			if len(filesToUpdate) > 0 && !opts.SkipReformat {
+				observer.OnReformat(ReformatReformatting, filesToUpdate)
 				if staging {
-					color.White("The following files need formatting:\n" + needsFormatting)
-					color.White("Automatically reformatting files.  Press <Enter> to review changes. Hit Ctrl-C at any point to abort commit.")
-
-					var s string
-					fmt.Scanln(&s)
-				} else {
-					color.White("Automatically reformatting the following files:\n" + needsFormatting)
+					observer.OnReformat(ReformatWaitingForReview, filesToUpdate)
+					if err := waitForEnter(ctx); err != nil {
+						return err
+					}
 				}
 
 				reformatCommand := check.Format.Command
@@ -49,33 +60,67 @@ func Reformat(ws Workspace, executor Executor, check ReformatCheck, staging bool
 				}
 
 				// Reformat the files
-				if err := executor.Execute(ws, reformatCommand); err != nil {
-					return fmt.Errorf("reformat check command failed: %s", err)
+				if err := executor.Execute(ctx, ws, reformatCommand); err != nil {
+					return err
 				}
 
 				// After reformatting, copy files from working dir to git work tree and then stage them
 				if staging {
-					MustRunCmd("rsync", append([]string{"-R"}, append(filesToUpdate, ws.GitDir)...)...)
+					if err := copyToGitRoot(ws, filesToUpdate); err != nil {
+						return err
+					}
 
-					MustRunInteractiveCmd("git", append([]string{"-C", ws.GitDir, "add", "--"}, filesToUpdate...)...)
-					MustRunInteractiveCmd("git", append([]string{"-C", ws.GitDir, "diff", "--"}, filesToUpdate...)...)
+					for _, gitCmd := range [][]string{{"add"}, {"diff"}} {
+						args := append(append(gitCmd, "--"), filesToUpdate...)
+						if err := RunInteractiveCmd("git", append([]string{"-C", ws.GitDir}, args...)...); err != nil {
+							return &GitError{Args: args, Err: err}
+						}
+					}
 
-					MustRunCmd("rsync", append([]string{"-R"}, append(filesToUpdate, ws.GitDir)...)...)
+					if err := copyToGitRoot(ws, filesToUpdate); err != nil {
+						return err
+					}
+					observer.OnReformat(ReformatStaged, filesToUpdate)
 				}
 
-				output, err := executor.ExecuteWithOutput(ws, checkCommand)
+				output, err := executor.ExecuteWithOutput(ctx, ws, checkCommand)
 				if err != nil {
-					return fmt.Errorf("reformat check command failed: %s", err)
+					return err
 				}
 				needsFormatting = string(output)
 			}
 
 			if needsFormatting != "" {
-				Failf("The following files still need reformatting:\n" + needsFormatting + "\n")
+				observer.OnReformat(ReformatStillNeedsFormatting, strings.Fields(needsFormatting))
+				return fmt.Errorf("the following files still need reformatting:\n%s", needsFormatting)
 			}
 		} else {
-			color.Green("No files need reformatting!")
+			observer.OnReformat(ReformatNotNeeded, nil)
 		}
 	}
 	return nil
 }
+
+func copyToGitRoot(ws Workspace, files []string) error {
+	if output, err := RunCmd("rsync", append([]string{"-R"}, append(files, ws.GitDir)...)...); err != nil {
+		return &WorkspaceError{Op: "copy reformatted files to " + ws.GitDir, Err: cmdError(err, output)}
+	}
+	return nil
+}
+
+// Waits for the user to hit <Enter>, giving up if ctx is done first
+func waitForEnter(ctx context.Context) error {
+	entered := make(chan struct{})
+	go func() {
+		var s string
+		fmt.Scanln(&s)
+		close(entered)
+	}()
+
+	select {
+	case <-entered:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,55 +1,64 @@
 package lib
 
 import (
-	"sync"
+	"context"
+	"runtime"
 )
 
-func RunCheck(ws Workspace, executor Executor, check Check, staging bool, skipReformat bool, err chan<- error) {
+type RunOptions struct {
+	Staging       bool           // Whether we are checking the git index
+	SkipReformat  bool           // Don't run reformat checks
+	KeepGoing     bool           // Keep running checks after a failure
+	Summary       *Summary       // Records the result of each command, if set
+	Observer      Observer       // Told about the progress of the run, or nil to print to the console
+	MaxParallel   int            // Most commands to run at once, or zero for GOMAXPROCS
+	Cache         *Cache         // Where to remember commands that passed, if anywhere.  Don't set this for dry runs.
+	CommitMessage *CommitMessage // Message of the commit being made, for commit_message checks, if any
+}
+
+// Runs check, sending the result of each command to err.  Commands start once the commands they need have passed.
+// No more than opts.MaxParallel commands run at once.  Nothing new is started once ctx is done, and running commands
+// are stopped.  Failures of commands that allow them are recorded but not sent.  If check fails without any command
+// failing, its own error is sent.  opts.Observer is told that ws is ready before anything runs, and about the end of
+// the run once everything has finished.
+func RunCheck(ctx context.Context, ws Workspace, executor Executor, check Check, opts RunOptions, err chan<- error) {
 	defer close(err)
 
-	switch check := check.(type) {
-	case SingleCheck:
-		err <- executor.Execute(ws, check.Command)
-	case ReformatCheck:
-		if !skipReformat {
-			err <- Reformat(ws, executor, check, staging, skipReformat)
-		}
-	case ManyChecks:
-		wg := sync.WaitGroup{}
-		childErrs := make([]chan error, len(check.Checks))
-		stopEarly := make(chan error, 1)
-	OUTER:
-		for i, childCheck := range check.Checks {
+	observer := observerOrConsole(opts.Observer, false)
+	observer.OnWorkspaceReady(ws)
 
-			// Stop if we've had a failure already
-			select {
-			case <-stopEarly:
-				break OUTER
-			default:
-			}
+	// Pass each error on, remembering them so we know whether any command failed
+	errs := make(chan error)
+	sent := make(chan []error)
+	go func() {
+		var all []error
+		for e := range errs {
+			err <- e
+			all = append(all, e)
+		}
+		sent <- all
+	}()
 
-			childErr := make(chan error)
-			childErrs[i] = childErr
-			wg.Add(1)
+	maxParallel := opts.MaxParallel
+	if maxParallel <= 0 {
+		maxParallel = runtime.GOMAXPROCS(0)
+	}
+	env := Env{
+		Workspace: ws,
+		Executor:  executor,
+		Options:   opts,
+		errs:      errs,
+		schedule:  newSchedule(check),
+		slots:     []chan struct{}{make(chan struct{}, maxParallel)},
+	}
+	result := check.Run(ctx, env)
+	env.finish(check, result)
+	close(errs)
 
-			go func() {
-				for {
-					if childErr, ok := <-childErr; ok {
-						err <- childErr // Forward errors to the parent
-						if childErr != nil {
-							stopEarly <- childErr
-						}
-					} else {
-						wg.Done()
-						break
-					}
-				}
-			}()
-			go RunCheck(ws, executor, childCheck, staging, skipReformat, childErrs[i])
-			if !check.Parallel {
-				wg.Wait()
-			}
-		}
-		wg.Wait()
+	sentErrs := <-sent
+	if result.Failed() && RunError(sentErrs) == nil {
+		err <- result.Err
+		sentErrs = append(sentErrs, result.Err)
 	}
+	observer.OnRunEnd(check, opts.Summary, RunError(sentErrs))
 }
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// The SARIF base URI that locations are relative to, which is the git root
+const sarifSourceRoot = "SRCROOT"
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds"`
+	Invocations        []sarifInvocation                `json:"invocations"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name string `json:"name"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful bool `json:"executionSuccessful"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// Writes the diagnostics found in the output of each command in check as a SARIF log, with one run per command that
+// ran.  Locations are relative to the git root.
+func WriteSARIFReport(w io.Writer, ws Workspace, check Check, summary *Summary) error {
+	log := sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{}}
+	sarifRuns(&log, ws, check, summary)
+
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func sarifRuns(log *sarifLog, ws Workspace, check Check, summary *Summary) {
+	if name := check.Name(); name != "" {
+		if result, found := summary.Result(name); found {
+			log.Runs = append(log.Runs, sarifCheckRun(ws, checkCommand(check), result))
+		}
+	}
+	for _, childCheck := range check.Children() {
+		sarifRuns(log, ws, childCheck, summary)
+	}
+}
+
+// The command that check runs, for finding diagnostics in its output
+func checkCommand(check Check) Command {
+	if check, ok := check.(CommandCheck); ok {
+		return check.CheckCommand()
+	}
+	return Command{Name: check.Name()}
+}
+
+func sarifCheckRun(ws Workspace, cmd Command, result Result) sarifRun {
+	level := "warning"
+	if result.Status == FAIL || result.Status == TIMEOUT {
+		level = "error"
+	}
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{Name: cmd.Name}},
+		OriginalURIBaseIDs: map[string]sarifArtifactLocation{
+			sarifSourceRoot: {URI: "file://" + filepath.ToSlash(ws.GitDir) + "/"},
+		},
+		Invocations: []sarifInvocation{{ExecutionSuccessful: result.Status == PASS || result.Status == CACHED}},
+		Results:     []sarifResult{},
+	}
+	for _, diagnostic := range parseDiagnostics(cmd.diagnosticRegexp(), result.Output) {
+		message := diagnostic.Message
+		if message == "" {
+			message = fmt.Sprintf("%s reported a problem", cmd.Name)
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  cmd.Name,
+			Level:   level,
+			Message: sarifMessage{Text: message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{
+						URI:       strings.TrimPrefix(filepath.ToSlash(ws.relativePath("", diagnostic.File)), "./"),
+						URIBaseID: sarifSourceRoot,
+					},
+					Region: sarifRegion{StartLine: diagnostic.Line, StartColumn: diagnostic.Column},
+				},
+			}},
+		})
+	}
+	return run
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSARIFReport(t *testing.T) {
+	check, err := parse(t, `[vet, {run: {name: lint, command: lint, diagnostics: "^(?P<file>[^(]+)\\((?P<line>\\d+)\\): (?P<message>.*)$"}}, skipped]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ws := Workspace{GitDir: "/src/repo", RootDir: "/tmp/work/repo"}
+	summary := NewSummary()
+	env := Env{Options: RunOptions{Summary: summary}}
+	env.Report("vet", time.Now(), []byte("# example.com/lib\n/tmp/work/repo/lib/a.go:3:7: unreachable code\n"), errors.New("exit status 1"), false)
+	env.Report("lint", time.Now(), []byte("lib/b.go(12): exported function needs a comment\n"), nil, true)
+
+	var report bytes.Buffer
+	if !assert.NoError(t, WriteSARIFReport(&report, ws, check, summary)) {
+		return
+	}
+
+	var log sarifLog
+	if !assert.NoError(t, json.Unmarshal(report.Bytes(), &log)) {
+		return
+	}
+	assert.Equal(t, "2.1.0", log.Version)
+	if !assert.Len(t, log.Runs, 2) {
+		return
+	}
+
+	vet := log.Runs[0]
+	assert.Equal(t, "vet", vet.Tool.Driver.Name)
+	assert.Equal(t, "file:///src/repo/", vet.OriginalURIBaseIDs[sarifSourceRoot].URI)
+	assert.Equal(t, []sarifResult{{
+		RuleID:  "vet",
+		Level:   "error",
+		Message: sarifMessage{Text: "unreachable code"},
+		Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: "lib/a.go", URIBaseID: sarifSourceRoot},
+			Region:           sarifRegion{StartLine: 3, StartColumn: 7},
+		}}},
+	}}, vet.Results)
+
+	lint := log.Runs[1]
+	if assert.Len(t, lint.Results, 1) {
+		assert.Equal(t, "warning", lint.Results[0].Level)
+		assert.Equal(t, "exported function needs a comment", lint.Results[0].Message.Text)
+		assert.Equal(t, sarifRegion{StartLine: 12}, lint.Results[0].Locations[0].PhysicalLocation.Region)
+	}
+}
@@ -0,0 +1,299 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Tracks which commands in a run have finished so that commands can wait for the ones they need
+type schedule struct {
+	lock    sync.Mutex
+	done    map[string]chan struct{}
+	results map[string]Result
+}
+
+func newSchedule(check Check) *schedule {
+	s := &schedule{done: map[string]chan struct{}{}, results: map[string]Result{}}
+	walkChecks(check, "", func(check Check, path string) {
+		if name := check.Name(); name != "" {
+			s.done[name] = make(chan struct{})
+		}
+	})
+	return s
+}
+
+// Records that a command has finished, releasing the commands that need it
+func (s *schedule) finish(result Result) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, finished := s.results[result.Name]; finished {
+		return
+	}
+	if done, found := s.done[result.Name]; found {
+		s.results[result.Name] = result
+		close(done)
+	}
+}
+
+// Whether the named command has finished, or isn't one we're waiting for
+func (s *schedule) finished(name string) bool {
+	if s == nil {
+		return true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, finished := s.results[name]
+	return finished || s.done[name] == nil
+}
+
+// Finishes every command in check that hasn't finished yet as SKIPPED
+func (s *schedule) skip(check Check) {
+	if s == nil {
+		return
+	}
+	walkChecks(check, "", func(check Check, path string) {
+		if name := check.Name(); name != "" {
+			s.finish(Result{Name: name, Status: SKIPPED})
+		}
+	})
+}
+
+// Waits for the named commands to finish, returning whether they all passed.  Gives up if ctx is done first.
+func (s *schedule) wait(ctx context.Context, names []string) bool {
+	if s == nil {
+		return true
+	}
+	for _, name := range names {
+		s.lock.Lock()
+		done := s.done[name]
+		s.lock.Unlock()
+		if done == nil {
+			return false
+		}
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return false
+		}
+
+		s.lock.Lock()
+		result := s.results[name]
+		s.lock.Unlock()
+		if result.Status != PASS && result.Status != CACHED {
+			return false
+		}
+	}
+	return true
+}
+
+// Calls visit for check and everything in it, with paths matching the ones used by the parser
+func walkChecks(check Check, path string, visit func(check Check, path string)) {
+	visit(check, path)
+	for i, childCheck := range check.Children() {
+		walkChecks(childCheck, childPath(path, check, i), visit)
+	}
+}
+
+// The names of the commands a check needs before it can run
+func checkNeeds(check Check) []string {
+	if check, ok := check.(CommandCheck); ok {
+		return check.CheckCommand().Needs
+	}
+	return nil
+}
+
+type dependency struct {
+	name     string
+	implicit bool // Whether this comes from the order of a sequence rather than from 'needs'
+}
+
+// The commands each command waits for, from its 'needs' and from the sequences it is in
+func checkDependencies(root Check) map[string][]dependency {
+	dependencies := map[string][]dependency{}
+	walkChecks(root, "", func(check Check, path string) {
+		for _, need := range checkNeeds(check) {
+			dependencies[check.Name()] = append(dependencies[check.Name()], dependency{name: need})
+		}
+
+		// Everything in a sequence waits for everything before it
+		if many, ok := check.(ManyChecks); ok && !many.Parallel {
+			for i := 1; i < len(many.Checks); i++ {
+				for _, before := range commandNames(many.Checks[i-1]) {
+					for _, after := range commandNames(many.Checks[i]) {
+						dependencies[after] = append(dependencies[after], dependency{name: before, implicit: true})
+					}
+				}
+			}
+		}
+	})
+	return dependencies
+}
+
+// Makes sure that every command named in 'needs' exists and that no command ends up waiting for itself, either
+// directly or because it needs a command that runs after it in a sequence
+func validateNeeds(root Check) error {
+	paths := map[string]string{}
+	var names []string
+	walkChecks(root, "", func(check Check, path string) {
+		if name := check.Name(); name != "" {
+			paths[name] = orRoot(path)
+			names = append(names, name)
+		}
+	})
+
+	var err error
+	walkChecks(root, "", func(check Check, path string) {
+		for _, need := range checkNeeds(check) {
+			if _, found := paths[need]; !found && err == nil {
+				err = fmt.Errorf("unknown step '%s' in 'needs' at %s", need, orRoot(path))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	dependencies := checkDependencies(root)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var stack []dependency
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		state[name] = visiting
+		for _, dep := range dependencies[name] {
+			switch state[dep.name] {
+			case visiting:
+				return cycleError(paths, append(stack, dependency{name: name}, dep))
+			case unvisited:
+				stack = append(stack, dependency{name: name}, dep)
+				if err := visit(dep.name); err != nil {
+					return err
+				}
+				stack = stack[:len(stack)-2]
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, name := range names {
+		if state[name] == unvisited {
+			if err := visit(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Describes a cycle from a stack of (command, dependency) pairs, starting where the last dependency closes it
+func cycleError(paths map[string]string, stack []dependency) error {
+	last := stack[len(stack)-1].name
+	start := 0
+	for i := 0; i < len(stack); i += 2 {
+		if stack[i].name == last {
+			start = i
+			break
+		}
+	}
+
+	var steps []string
+	for i := start; i < len(stack); i += 2 {
+		from, to := stack[i], stack[i+1]
+		if to.implicit {
+			steps = append(steps, fmt.Sprintf("'%s' runs after '%s'", from.name, to.name))
+		} else {
+			steps = append(steps, fmt.Sprintf("'%s' needs '%s'", from.name, to.name))
+		}
+	}
+	return fmt.Errorf("dependency cycle at %s: %s", paths[last], strings.Join(steps, ", "))
+}
+
+// The names of the commands in check
+func commandNames(check Check) (names []string) {
+	walkChecks(check, "", func(check Check, path string) {
+		if name := check.Name(); name != "" {
+			names = append(names, name)
+		}
+	})
+	return names
+}
+
+// Returns just the named check and the checks it needs, directly or indirectly, including the ones it runs after in a
+// sequence.  They are kept in the blocks they were in, so they run in the same order as they would in the whole config.
+func SelectCheck(root Check, name string) (Check, error) {
+	checks := map[string]Check{}
+	walkChecks(root, "", func(check Check, path string) {
+		if check.Name() != "" {
+			checks[check.Name()] = check
+		}
+	})
+	if checks[name] == nil {
+		return nil, fmt.Errorf("no step named '%s' in the config", name)
+	}
+	dependencies := checkDependencies(root)
+	return selectChecks(root, []string{name}, func(name string) (names []string) {
+		for _, dep := range dependencies[name] {
+			names = append(names, dep.name)
+		}
+		return names
+	}), nil
+}
+
+// Returns root with only the named checks and the checks they need, as returned by needs, directly or indirectly
+func selectChecks(root Check, names []string, needs func(name string) []string) Check {
+	selected := map[string]bool{}
+	var selectNeeds func(name string)
+	selectNeeds = func(name string) {
+		if selected[name] {
+			return
+		}
+		selected[name] = true
+		for _, need := range needs(name) {
+			selectNeeds(need)
+		}
+	}
+	for _, name := range names {
+		selectNeeds(name)
+	}
+
+	if check, ok := pruneChecks(root, selected); ok {
+		return check
+	}
+	return ManyChecks{}
+}
+
+// Returns check with only the selected commands in it, and whether there are any.  Blocks we don't know the insides of
+// are kept whole if any of their commands are selected.
+func pruneChecks(check Check, selected map[string]bool) (Check, bool) {
+	if name := check.Name(); name != "" {
+		return check, selected[name]
+	}
+	many, ok := check.(ManyChecks)
+	if !ok {
+		for _, name := range commandNames(check) {
+			if selected[name] {
+				return check, true
+			}
+		}
+		return check, false
+	}
+	var checks []Check
+	for _, childCheck := range many.Checks {
+		if kept, ok := pruneChecks(childCheck, selected); ok {
+			checks = append(checks, kept)
+		}
+	}
+	many.Checks = checks
+	return many, len(checks) > 0
+}
@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNeeds(t *testing.T) {
+	specs := []struct {
+		data        string
+		expectedErr string
+	}{
+		{`{parallel: [build, {run: {name: test, command: go test, needs: [build]}}]}`, ""},
+		{`[build, {parallel: [vet, {run: {name: test, command: go test, needs: [build, vet]}}]}]`, ""},
+		{`[build, {run: {name: test, command: go test, needs: [lint]}}]`, "unknown step 'lint' in 'needs' at /2"},
+		{`{parallel: [{run: {name: a, command: a, needs: [b]}}, {run: {name: b, command: b, needs: [a]}}]}`,
+			"dependency cycle at /parallel/1: 'a' needs 'b', 'b' needs 'a'"},
+		{`{parallel: [{run: {name: a, command: a, needs: [a]}}]}`, "dependency cycle at /parallel/1: 'a' needs 'a'"},
+		{`[{run: {name: test, command: go test, needs: [build]}}, build]`,
+			"dependency cycle at /1: 'test' needs 'build', 'build' runs after 'test'"},
+	}
+
+	for i, spec := range specs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			_, err := parse(t, spec.data)
+			if spec.expectedErr != "" {
+				assert.EqualError(t, err, spec.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// Runs each command by calling a function instead of a shell, so that a test can control when it finishes
+type funcExecutor func(cmd Command) error
+
+func (run funcExecutor) Execute(ctx context.Context, ws Workspace, cmd Command) error {
+	return run(cmd)
+}
+
+func (run funcExecutor) ExecuteWithOutput(ctx context.Context, ws Workspace, cmd Command) ([]byte, error) {
+	return nil, run(cmd)
+}
+
+func TestNeedsScheduling(t *testing.T) {
+	check, err := parse(t, `
+parallel:
+  - run: {name: test, command: echo test, needs: [build]}
+  - run: {name: build, command: echo build}
+  - run: {name: lint, command: exit 1, allow_failure: true}
+  - run: {name: report, command: echo report, needs: [lint]}
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Hold build until lint has finished, so that test would run first if it didn't wait for build.  Every command can
+	// run at once, so that build waiting doesn't keep lint from starting.
+	var lock sync.Mutex
+	var events []string
+	record := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	lintDone := make(chan struct{})
+	executor := funcExecutor(func(cmd Command) error {
+		record("start " + cmd.Name)
+		defer record("end " + cmd.Name)
+		switch cmd.Name {
+		case "build":
+			<-lintDone
+		case "lint":
+			defer close(lintDone)
+			return errors.New("exit status 1")
+		}
+		return nil
+	})
+
+	summary := NewSummary()
+	errs := make(chan error, 4)
+	RunCheck(context.Background(), Workspace{}, executor, check, RunOptions{Summary: summary, MaxParallel: 4, Observer: Observers{}}, errs)
+
+	var ordered []string
+	for _, event := range events {
+		if event == "end build" || event == "start test" {
+			ordered = append(ordered, event)
+		}
+	}
+	assert.Equal(t, []string{"end build", "start test"}, ordered)
+
+	var statuses []string
+	for _, result := range summary.Results(check) {
+		statuses = append(statuses, result.Name+"="+result.Status.String())
+	}
+	assert.Equal(t, []string{"test=PASS", "build=PASS", "lint=ALLOWED-FAILURE", "report=SKIPPED"}, statuses)
+}
+
+func TestMaxParallel(t *testing.T) {
+	specs := []struct {
+		data        string
+		maxParallel int
+		expected    int
+	}{
+		{`parallel: [a, b, c, d]`, 2, 2},
+		{`{parallel: [a, b, c], max_parallel: 1}`, 4, 1},
+		{`parallel: [{parallel: [a, b], max_parallel: 1}, c, d]`, 4, 3},
+		{`parallel: [{parallel: [a, b, c], max_parallel: 1}, d, e]`, 2, 2},
+	}
+
+	for i, spec := range specs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			check, err := parse(t, spec.data)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Each command counts itself as running and then waits, so that as many run at once as are allowed to.
+			// Once the expected number are running, they are all let go.
+			var running, mostRunning int32
+			started := make(chan struct{}, 5)
+			release := make(chan struct{})
+			executor := funcExecutor(func(cmd Command) error {
+				now := atomic.AddInt32(&running, 1)
+				for {
+					most := atomic.LoadInt32(&mostRunning)
+					if now <= most || atomic.CompareAndSwapInt32(&mostRunning, most, now) {
+						break
+					}
+				}
+				started <- struct{}{}
+				<-release
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				errs := make(chan error, 5)
+				RunCheck(context.Background(), Workspace{}, executor, check, RunOptions{MaxParallel: spec.maxParallel, Observer: Observers{}}, errs)
+			}()
+			for n := 0; n < spec.expected; n++ {
+				select {
+				case <-started:
+				case <-time.After(10 * time.Second):
+					t.Fatalf("only %d command(s) started", n)
+				}
+			}
+			close(release)
+			<-done
+
+			assert.Equal(t, int32(spec.expected), atomic.LoadInt32(&mostRunning))
+		})
+	}
+}
+
+func TestSelectCheck(t *testing.T) {
+	check, err := parse(t, `[build, {parallel: [vet, {run: {name: test, command: go test, needs: [gen]}}, {run: {name: gen, command: go generate, needs: [build]}}]}]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	selected, err := SelectCheck(check, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, ManyChecks{Checks: []Check{
+		SingleCheck{Command: Command{Name: "build", Command: "build"}},
+		ManyChecks{Checks: []Check{
+			SingleCheck{Command: Command{Name: "test", Command: "go test", Needs: []string{"gen"}}},
+			SingleCheck{Command: Command{Name: "gen", Command: "go generate", Needs: []string{"build"}}},
+		}, Parallel: true},
+	}}, selected, "the steps keep the blocks they were in")
+
+	// Steps earlier in a sequence are needed too
+	selected, err = SelectCheck(check, "vet")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"build", "vet"}, commandNames(selected))
+	selected, err = SelectCheck(check, "build")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"build"}, commandNames(selected))
+
+	_, err = SelectCheck(check, "lint")
+	assert.EqualError(t, err, "no step named 'lint' in the config")
+}
+
+// A custom kind of check that runs a command
+type customCommandCheck struct {
+	returningCheck
+	needs []string
+}
+
+func (check customCommandCheck) CheckCommand() Command {
+	return Command{Name: check.name, Needs: check.needs}
+}
+
+func TestCustomCheckNeeds(t *testing.T) {
+	build := SingleCheck{Command: Command{Name: "build", Command: "go build"}}
+	check := ManyChecks{Checks: []Check{build, customCommandCheck{returningCheck: returningCheck{name: "custom"}, needs: []string{"lint"}}}}
+	assert.EqualError(t, validateNeeds(check), "unknown step 'lint' in 'needs' at /2")
+
+	check = ManyChecks{Checks: []Check{build, SingleCheck{Command: Command{Name: "vet", Command: "go vet"}},
+		customCommandCheck{returningCheck: returningCheck{name: "custom"}, needs: []string{"build"}}}, Parallel: true}
+	assert.NoError(t, validateNeeds(check))
+	selected, err := SelectCheck(check, "custom")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"build", "custom"}, commandNames(selected))
+}
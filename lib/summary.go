@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+type Result struct {
+	Name     string
+	Status   CmdStatus
+	Duration time.Duration
+	Output   []byte
+	Err      error
+	Ignored  int // Diagnostics dropped by the command's filter
+}
+
+// Whether the result should fail the run
+func (r Result) Failed() bool {
+	return r.Err != nil && r.Status != ALLOWED_FAILURE
+}
+
+// Summary records the result of each command in a run so they can be reported at the end
+type Summary struct {
+	lock    sync.Mutex
+	results map[string]Result
+}
+
+func NewSummary() *Summary {
+	return &Summary{results: map[string]Result{}}
+}
+
+func (s *Summary) add(result Result) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.results[result.Name] = result
+}
+
+func (s *Summary) Result(name string) (Result, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result, found := s.results[name]
+	return result, found
+}
+
+// Returns the result of every command in check in the order they appear in the config.  Commands that never ran are
+// SKIPPED.
+func (s *Summary) Results(check Check) (results []Result) {
+	if name := check.Name(); name != "" {
+		results = append(results, s.resultOrSkipped(name))
+	}
+	for _, childCheck := range check.Children() {
+		results = append(results, s.Results(childCheck)...)
+	}
+	return results
+}
+
+func (s *Summary) resultOrSkipped(name string) Result {
+	if result, found := s.Result(name); found {
+		return result
+	}
+	return Result{Name: name, Status: SKIPPED}
+}
+
+func (s *Summary) Print(check Check) {
+	results := s.Results(check)
+	if len(results) == 0 {
+		return
+	}
+
+	nameWidth := 0
+	for _, result := range results {
+		if len(result.Name) > nameWidth {
+			nameWidth = len(result.Name)
+		}
+	}
+
+	color.Yellow("Summary:")
+	for _, result := range results {
+		line := fmt.Sprintf("  %-15s %-*s", result.Status, nameWidth, result.Name)
+		if result.Status != SKIPPED {
+			line += fmt.Sprintf(" %0.3fs", seconds(result.Duration))
+		}
+		color.New(StatusToColor[result.Status]).Println(strings.TrimRight(line, " "))
+	}
+}
+
+// Works out how to report a command that finished with err
+func resultStatus(err error, allowFailure bool) CmdStatus {
+	if err == nil {
+		return PASS
+	}
+	if IsCanceled(err) {
+		return CANCELED
+	}
+	if allowFailure {
+		return ALLOWED_FAILURE
+	}
+	if failure, ok := err.(*CommandFailedError); ok && failure.TimedOut() {
+		return TIMEOUT
+	}
+	return FAIL
+}
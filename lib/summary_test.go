@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryResults(t *testing.T) {
+	check, err := parse(t, `[{parallel: [a, b, c]}, d]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	summary := NewSummary()
+	env := Env{Options: RunOptions{Summary: summary}}
+	start := time.Now()
+	env.Report("c", start, nil, &CommandFailedError{Name: "c", Err: ErrTimeout}, false)
+	env.Report("a", start, nil, nil, false)
+	env.Report("b", start, nil, errors.New("exit status 1"), true)
+
+	var statuses []string
+	for _, result := range summary.Results(check) {
+		statuses = append(statuses, result.Name+"="+result.Status.String())
+	}
+	assert.Equal(t, []string{"a=PASS", "b=ALLOWED-FAILURE", "c=TIMEOUT", "d=SKIPPED"}, statuses)
+}
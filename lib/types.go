@@ -1,17 +1,266 @@
 package lib
 
-type Check interface{}
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check is a step of a config.  Commands are checks with names and blocks of commands are checks with children.
+type Check interface {
+	Name() string // Unique name of the check, or "" for a block
+	Children() []Check
+	Run(ctx context.Context, env Env) Result // Runs the check, reporting the result of each command to env
+}
+
+// CommandCheck is implemented by checks that run a command, or something described like one.  The command's needs are
+// validated and followed when selecting steps, and its settings are used when reporting its result.
+type CommandCheck interface {
+	Check
+	CheckCommand() Command
+}
+
+// Env is what checks are run with.  Custom kinds of check run their commands as SingleCheck does: they Wait for the
+// commands they need and AcquireSlots before running, then Report the result, or return Skipped if they don't run.  A
+// named check that only returns its result has it reported for it.
+type Env struct {
+	Workspace Workspace
+	Executor  Executor
+	Options   RunOptions
+	errs      chan<- error
+	schedule  *schedule
+	slots     []chan struct{} // Limits on the number of commands running at once, outermost first
+}
+
+// Records the result of a command that has finished and sends its error to whoever is running the checks.  Failures
+// of commands that allow them are recorded but not sent.
+func (env Env) Report(name string, start time.Time, output []byte, err error, allowFailure bool) Result {
+	return env.report(Result{
+		Name:     name,
+		Status:   resultStatus(err, allowFailure),
+		Duration: time.Since(start),
+		Output:   output,
+		Err:      err,
+	})
+}
+
+func (env Env) report(result Result) Result {
+	if env.Options.Summary != nil {
+		env.Options.Summary.add(result)
+	}
+	env.schedule.finish(result)
+	if env.errs != nil {
+		if result.Failed() {
+			env.errs <- result.Err
+		} else {
+			env.errs <- nil
+		}
+	}
+	return result
+}
+
+// Records that the named command won't run
+func (env Env) Skipped(name string) Result {
+	result := Result{Name: name, Status: SKIPPED}
+	env.schedule.finish(result)
+	return result
+}
+
+// Waits for the named commands to finish, returning whether they all passed.  Gives up if ctx is done first.
+func (env Env) Wait(ctx context.Context, names []string) bool {
+	return env.schedule.wait(ctx, names)
+}
+
+// Reports the result a check returned if it's a command that didn't report it itself
+func (env Env) finish(check Check, result Result) {
+	name := check.Name()
+	if name == "" || env.schedule.finished(name) {
+		return
+	}
+	result.Name = name
+	env.report(result)
+}
+
+// Waits for a slot under every limit on the number of commands running at once, returning a function that gives them
+// back.  The innermost slot is taken first, so that a command waiting on its block's limit doesn't hold a slot that
+// commands outside the block could use.  Gives up if ctx is done first.
+func (env Env) AcquireSlots(ctx context.Context) (release func(), ok bool) {
+	var acquired []chan struct{}
+	release = func() {
+		for _, slots := range acquired {
+			<-slots
+		}
+	}
+	for i := len(env.slots) - 1; i >= 0; i-- {
+		slots := env.slots[i]
+		select {
+		case slots <- struct{}{}:
+			acquired = append(acquired, slots)
+		case <-ctx.Done():
+			release()
+			return nil, false
+		}
+	}
+	return release, true
+}
 
 type ManyChecks struct {
-	Checks   []Check
-	Parallel bool
+	Checks      []Check
+	Parallel    bool
+	MaxParallel int // Most commands in the block to run at once, or zero for no limit of its own
+}
+
+func (ManyChecks) Name() string {
+	return ""
+}
+
+func (check ManyChecks) Children() []Check {
+	return check.Checks
+}
+
+// Runs each child in turn, or all at once if the block is parallel.  Nothing new is started once ctx is done or, unless
+// we're keeping going, after a failure, and the commands that weren't started are finished as SKIPPED so that nothing
+// waits for them.  The result is that of the first child to fail.
+func (check ManyChecks) Run(ctx context.Context, env Env) Result {
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	lock := sync.Mutex{}
+	result := Result{Status: PASS}
+
+	if check.MaxParallel > 0 {
+		env.slots = append(append([]chan struct{}{}, env.slots...), make(chan struct{}, check.MaxParallel))
+	}
+
+	runChild := func(childCheck Check) {
+		childResult := childCheck.Run(ctx, env)
+		env.finish(childCheck, childResult)
+		env.schedule.skip(childCheck)
+		lock.Lock()
+		defer lock.Unlock()
+		if childResult.Failed() && result.Err == nil {
+			result.Status = childResult.Status
+			result.Err = childResult.Err
+		}
+	}
+
+	for i, childCheck := range check.Checks {
+		// Stop if we've had a failure already or have been canceled
+		lock.Lock()
+		failed := result.Err != nil
+		lock.Unlock()
+		if failed && !env.Options.KeepGoing || ctx.Err() != nil {
+			for _, skippedCheck := range check.Checks[i:] {
+				env.schedule.skip(skippedCheck)
+			}
+			break
+		}
+
+		if check.Parallel {
+			wg.Add(1)
+			go func(childCheck Check) {
+				defer wg.Done()
+				runChild(childCheck)
+			}(childCheck)
+		} else {
+			runChild(childCheck)
+		}
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(start)
+	return result
 }
 
 type SingleCheck struct {
 	Command
 }
 
+func (check SingleCheck) Name() string {
+	return check.Command.Name
+}
+
+func (SingleCheck) Children() []Check {
+	return nil
+}
+
+func (check SingleCheck) CheckCommand() Command {
+	return check.Command
+}
+
+// Runs the command once the commands it needs have passed and there is a slot for it to run in.  It is SKIPPED if any
+// of the commands it needs don't pass, and CACHED if it has already passed with the same inputs.
+func (check SingleCheck) Run(ctx context.Context, env Env) Result {
+	if !env.Wait(ctx, check.Needs) {
+		return env.Skipped(check.Name())
+	}
+
+	cacheKey, cacheable := env.Options.Cache.key(env.Workspace, check.Command, env.Options.Staging)
+	if cacheable {
+		if entry, found := env.Options.Cache.lookup(cacheKey); found {
+			return env.report(check.cached(env, entry))
+		}
+	}
+
+	release, ok := env.AcquireSlots(ctx)
+	if !ok {
+		return env.Skipped(check.Name())
+	}
+	defer release()
+
+	start := time.Now()
+	output, err := env.Executor.ExecuteWithOutput(ctx, env.Workspace, check.Command)
+	result := env.Report(check.Name(), start, output, err, check.AllowFailure)
+	if cacheable && result.Status == PASS {
+		env.Options.Cache.store(cacheKey, result)
+	}
+	return result
+}
+
+// Reports a command that passed before with the same inputs as it was reported then, with the time it took then
+func (check SingleCheck) cached(env Env, entry cacheEntry) Result {
+	observer := observerOrConsole(env.Options.Observer, false)
+	observer.OnCheckStart(check.Command)
+	lines := outputWriter(observer, check.Command)
+	lines.Write(entry.Output)
+	lines.Flush()
+	result := Result{
+		Name:     check.Name(),
+		Status:   CACHED,
+		Duration: time.Duration(entry.Duration * float64(time.Second)),
+		Output:   entry.Output,
+	}
+	observer.OnCheckEnd(check.Command, result)
+	return result
+}
+
 type ReformatCheck struct {
 	Check  SingleCheck
 	Format SingleCheck
 }
+
+func (check ReformatCheck) Name() string {
+	return check.Check.Name()
+}
+
+func (ReformatCheck) Children() []Check {
+	return nil
+}
+
+func (check ReformatCheck) CheckCommand() Command {
+	return check.Check.Command
+}
+
+func (check ReformatCheck) Run(ctx context.Context, env Env) Result {
+	if env.Options.SkipReformat || !env.Wait(ctx, check.Check.Needs) {
+		return env.Skipped(check.Name())
+	}
+	release, ok := env.AcquireSlots(ctx)
+	if !ok {
+		return env.Skipped(check.Name())
+	}
+	defer release()
+
+	start := time.Now()
+	err := Reformat(ctx, env.Workspace, env.Executor, check, env.Options)
+	return env.Report(check.Name(), start, nil, err, false)
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What we wrote into a warm workarea the last time it was synced
+type warmManifest struct {
+	RootDir string               `json:"root"`
+	Files   map[string]warmEntry `json:"files"`
+}
+
+type warmEntry struct {
+	Mode    string    `json:"mode"`
+	Blob    string    `json:"blob"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// Whether the file at fileName is still what we wrote for the entry, rather than something a command changed
+func (entry warmEntry) unchanged(fileName string) bool {
+	info, err := os.Lstat(fileName)
+	return err == nil && info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime)
+}
+
+func readWarmManifest(manifestFile string) warmManifest {
+	var manifest warmManifest
+	if data, err := ioutil.ReadFile(manifestFile); err == nil {
+		json.Unmarshal(data, &manifest) // A bad manifest just means everything is rewritten
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]warmEntry{}
+	}
+	return manifest
+}
+
+func (manifest warmManifest) write(manifestFile string) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tempFile := manifestFile + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, manifestFile)
+}
+
+// Makes the files at rootDir match the git index (or a revision, if sha is set) by only writing the files whose blobs
+// have changed since the last sync.  Files that commands have changed since then are written again, and anything else
+// in rootDir, such as files that commands have made, is removed unless it matches one of the keep patterns.
+func syncWarmWorkarea(gitRoot string, workDir string, rootDir string, sha string, keep []string) error {
+	manifestFile := workDir + ".manifest"
+	indexFile := ""
+	if sha != "" {
+		// Read the revision into an index of our own so that the one in use is left alone
+		indexFile = manifestFile + ".index"
+		defer os.Remove(indexFile)
+		if _, err := runIndexGit(gitRoot, indexFile, nil, "read-tree", sha); err != nil {
+			return err
+		}
+	}
+
+	output, err := runIndexGit(gitRoot, indexFile, nil, "ls-files", "--stage", "-z")
+	if err != nil {
+		return err
+	}
+
+	last := readWarmManifest(manifestFile)
+	if last.RootDir != rootDir {
+		// The layout of the workarea changed, so start again.  Only remove what we wrote if it's in the workarea, in case
+		// the manifest has been moved or tampered with.
+		for _, dir := range []string{last.RootDir, rootDir} {
+			if !isWithin(workDir, dir) {
+				continue
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+		last.Files = map[string]warmEntry{}
+	}
+	next := warmManifest{RootDir: rootDir, Files: map[string]warmEntry{}}
+
+	var changed []string
+	for _, line := range strings.Split(output, "\x00") {
+		// Each line is "<mode> <blob> <stage>\t<path>"
+		tab := strings.Index(line, "\t")
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) != 3 || fields[0] == "160000" { // Submodules aren't checked out
+			continue
+		}
+		file := line[tab+1:]
+		entry := warmEntry{Mode: fields[0], Blob: fields[1]}
+		if lastEntry, found := last.Files[file]; found && lastEntry.Mode == entry.Mode && lastEntry.Blob == entry.Blob &&
+			lastEntry.unchanged(filepath.Join(rootDir, file)) {
+			entry = lastEntry
+		} else {
+			changed = append(changed, file)
+		}
+		next.Files[file] = entry
+	}
+
+	if err := os.MkdirAll(rootDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// Remove whatever isn't in the index, as "git clean" does in a checkout
+	cleanArgs := []string{"--work-tree", rootDir, "clean", "-q", "-d", "-x", "-f", "-f"}
+	for _, pattern := range keep {
+		cleanArgs = append(cleanArgs, "-e", pattern)
+	}
+	if _, err := runIndexGit(gitRoot, indexFile, nil, cleanArgs...); err != nil {
+		return err
+	}
+
+	if len(changed) > 0 {
+		stdin := []byte(strings.Join(changed, "\x00") + "\x00")
+		if _, err := runIndexGit(gitRoot, indexFile, stdin, "checkout-index", "-f", "-z", "--stdin", "--prefix", rootDir+"/"); err != nil {
+			return err
+		}
+		for _, file := range changed {
+			info, err := os.Lstat(filepath.Join(rootDir, file))
+			if err != nil {
+				return err
+			}
+			entry := next.Files[file]
+			entry.Size, entry.ModTime = info.Size(), info.ModTime()
+			next.Files[file] = entry
+		}
+	}
+
+	return next.write(manifestFile)
+}
+
+// Whether path is a file or directory inside dir
+func isWithin(dir string, path string) bool {
+	if dir == "" || path == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncWarmWorkarea(t *testing.T) {
+	repo := newTestRepo(t)
+	gitRoot := repo.Root
+	git, write, read := repo.git, repo.write, repo.read
+	modTime := func(dir, file string) time.Time {
+		info, err := os.Stat(filepath.Join(dir, file))
+		assert.NoError(t, err)
+		return info.ModTime()
+	}
+
+	write(gitRoot, "a.go", "package a")
+	write(gitRoot, "b/b.go", "package b")
+	git("add", "a.go", "b/b.go")
+	first := repo.commit("first")
+
+	workDir := filepath.Join(gitRoot, ".git", "gogitix", "warm")
+	rootDir := filepath.Join(workDir, "repo")
+	sync := func(sha string) {
+		assert.NoError(t, syncWarmWorkarea(gitRoot, workDir, rootDir, sha, []string{"/.cache/"}))
+	}
+
+	sync("")
+	assert.Equal(t, "package a", read(rootDir, "a.go"))
+	assert.Equal(t, "package b", read(rootDir, "b/b.go"))
+	bModTime := modTime(rootDir, "b/b.go")
+
+	// Only the files that changed in the index are written
+	time.Sleep(10 * time.Millisecond)
+	write(gitRoot, "a.go", "package a // staged")
+	write(gitRoot, "c.go", "package c")
+	git("add", "a.go", "c.go")
+	write(gitRoot, "a.go", "package a // not staged")
+	write(rootDir, "made-by-a-command", "output")
+	write(rootDir, "b/made-by-a-command", "output")
+	write(rootDir, ".cache/kept", "cached")
+	sync("")
+	assert.Equal(t, "package a // staged", read(rootDir, "a.go"))
+	assert.Equal(t, "package c", read(rootDir, "c.go"))
+	assert.Equal(t, bModTime, modTime(rootDir, "b/b.go"))
+	assert.Equal(t, "<missing>", read(rootDir, "made-by-a-command"))
+	assert.Equal(t, "<missing>", read(rootDir, "b/made-by-a-command"))
+	assert.Equal(t, "cached", read(rootDir, ".cache/kept"), "files matching a keep pattern are kept")
+
+	// Removed files are deleted, and files changed in the workarea are written again
+	git("rm", "-q", "--cached", "c.go")
+	write(rootDir, "b/b.go", "package b // changed by a command")
+	sync("")
+	assert.Equal(t, "<missing>", read(rootDir, "c.go"))
+	assert.Equal(t, "package b", read(rootDir, "b/b.go"))
+
+	// Revisions are read without changing the index
+	index := git("ls-files", "--stage")
+	sync(first)
+	assert.Equal(t, "package a", read(rootDir, "a.go"))
+	assert.Equal(t, index, git("ls-files", "--stage"))
+
+	// A different layout starts from scratch
+	otherRootDir := filepath.Join(workDir, "src", "example.com", "repo")
+	assert.NoError(t, syncWarmWorkarea(gitRoot, workDir, otherRootDir, "", nil))
+	assert.Equal(t, "<missing>", read(rootDir, "a.go"))
+	assert.Equal(t, "package a // staged", read(otherRootDir, "a.go"))
+
+	// Nothing outside of the workarea is removed, whatever the manifest says
+	write(filepath.Dir(workDir), "warm.manifest", `{"root": "`+gitRoot+`", "files": {}}`)
+	sync("")
+	assert.Equal(t, "package a // not staged", read(gitRoot, "a.go"))
+	assert.Equal(t, "package a // staged", read(rootDir, "a.go"))
+}
@@ -1,88 +1,166 @@
 package lib
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/fatih/color"
+	"gopkg.in/launchdarkly/gogitix.v2/lib/utils"
+)
 
-	"log"
+const changedLinesFileName = "gogitix-changed-lines.json"
 
-	"gopkg.in/launchdarkly/gogitix.v2/lib/utils"
+// WorkareaStrategy is how Start makes the workarea for checking the git index or a revision
+type WorkareaStrategy int
+
+const (
+	TempWorkarea     WorkareaStrategy = iota // Copy the files into a new temporary directory, which is removed afterwards
+	WorktreeWorkarea                         // Check out into a git worktree under .git/gogitix, which is reused
+	WarmWorkarea                             // Keep a copy under .git/gogitix, only updating the files that changed
 )
 
 type Workspace struct {
-	GitDir              string   // Original git directory
-	WorkDir             string   // Base of the temporary directory created with git index
-	RootDir             string   // Base directory of the top-level go package in the git index
-	UpdatedDirs         []string // Directories that have changed and still exist (sorted)
-	UpdatedTrees        []string // Top directories that have changed and still exist (sorted)
-	UpdatedFiles        []string // Files that have changed and still exist
-	UpdatedPackages     []string // Packages that have changed and still exist
-	LocallyChangedFiles []string // Files where the git index differs from what's in the working tree
-	deleteOnClose       bool     // whether to delete the workspace when we are done
+	GitDir              string                 // Original git directory
+	WorkDir             string                 // Base of the temporary directory created with git index
+	RootDir             string                 // Base directory of the top-level go package in the git index
+	ModulePath          string                 // Path of the module at the git root, or empty if using GOPATH
+	Revision            string                 // Commit checked out into the workarea, if checking a revision
+	Modules             []Module               // All modules in the workspace, including nested ones
+	UpdatedDirs         []string               // Directories that have changed and still exist (sorted)
+	UpdatedTrees        []string               // Top directories that have changed and still exist (sorted)
+	UpdatedFiles        []string               // Files that have changed and still exist
+	AddedFiles          []string               // Files that were added (sorted)
+	ModifiedFiles       []string               // Files that were modified without being renamed (sorted)
+	DeletedFiles        []string               // Files that were deleted (sorted)
+	RenamedFiles        map[string]string      // New name of each renamed file, keyed by its old name
+	UpdatedPackages     []string               // Packages that have changed and still exist
+	ChangedLines        map[string][]LineRange // Added or modified lines in each updated file
+	ChangedLinesFile    string                 // JSON file containing ChangedLines
+	LocallyChangedFiles []string               // Files where the git index differs from what's in the working tree
+	importers           map[string][]string    // packages in the workspace that import each package
+	deleteOnClose       bool                   // whether to delete the workspace when we are done
+	worktree            bool                   // whether the workarea is a git worktree
+	lockFile            string                 // lock to remove when we are done with a reused workarea
 }
 
-func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string, staging bool) (Workspace, error) {
+func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string, staging bool) (ws Workspace, err error) {
+	return StartWithStrategy(gitRoot, pathSpec, useLndir, gitRevSpec, staging, TempWorkarea, nil)
+}
+
+// Like Start, but with a choice of how to make the workarea for checking the git index or a revision.  Files in a warm
+// workarea that match one of the keep patterns (as in .gitignore), such as caches, are kept between runs.
+func StartWithStrategy(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string, staging bool, strategy WorkareaStrategy, keep []string) (ws Workspace, err error) {
 	workDir := gitRoot
 	rootDir := gitRoot
-	rootPackage := strings.TrimSpace(MustRunCmd("sh", "-c", fmt.Sprintf("cd %s && go list -e .", gitRoot)))
+	inWorkarea := gitRevSpec != "" || staging
+	deleteOnClose := inWorkarea && strategy == TempWorkarea
+	worktree := inWorkarea && strategy == WorktreeWorkarea
+	warm := inWorkarea && strategy == WarmWorkarea
+	var lockFile string
+
+	if (worktree || warm) && useLndir {
+		return Workspace{}, &WorkspaceError{Op: "create links", Err: errors.New("lndir can't be used with a reused workarea")}
+	}
+
+	modulePath, err := findModulePath(gitRoot)
+	if err != nil {
+		return Workspace{}, &WorkspaceError{Op: "read go.mod", Err: err}
+	}
+
+	rootPackage := modulePath
+	if modulePath == "" {
+		// Without a go.mod, the go tool (and the commands we run) only works in GOPATH mode
+		if err := os.Setenv("GO111MODULE", "off"); err != nil {
+			return Workspace{}, &WorkspaceError{Op: "set GO111MODULE", Err: err}
+		}
+		output, err := RunCmdInDir(gitRoot, "go", "list", "-e", ".")
+		if err != nil {
+			return Workspace{}, &WorkspaceError{Op: "find root package", Err: cmdError(err, output)}
+		}
+		rootPackage = strings.TrimSpace(output)
+	}
 
 	// If we need to make a copy for staging of a revspec
-	if gitRevSpec != "" || staging {
-		var err error
-		workDir, err = ioutil.TempDir("", path.Base(os.Args[0]))
+	if worktree || warm {
+		dir, err := gogitixDir(gitRoot)
 		if err != nil {
 			return Workspace{}, err
 		}
+		if worktree {
+			workDir = filepath.Join(dir, "worktree")
+		} else {
+			workDir = filepath.Join(dir, "warm")
+		}
+		if lockFile, err = lockWorkarea(workDir); err != nil {
+			return Workspace{}, &WorkspaceError{Op: "lock workarea", Err: err}
+		}
 
-		workDir, _ = filepath.EvalSymlinks(workDir)
+		// Let the next run have the workarea if we can't finish setting it up
+		defer func() {
+			if err != nil {
+				os.Remove(lockFile)
+			}
+		}()
 
-		if err := os.Setenv("GOPATH", strings.Join([]string{workDir, os.Getenv("GOPATH")}, ":")); err != nil {
-			return Workspace{}, err
+		if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
+			return Workspace{}, &WorkspaceError{Op: "create workarea", Err: err}
+		}
+		workDir, _ = filepath.EvalSymlinks(workDir)
+	} else if deleteOnClose {
+		workDir, err = ioutil.TempDir("", path.Base(os.Args[0]))
+		if err != nil {
+			return Workspace{}, &WorkspaceError{Op: "create workarea", Err: err}
 		}
 
-		rootDir = path.Join(workDir, "src", rootPackage)
-	}
+		// Don't leave the workarea behind if we can't finish setting it up
+		defer func() {
+			if err != nil {
+				os.RemoveAll(workDir)
+			}
+		}()
 
-	yellow := color.New(color.FgYellow)
-	yellow.Printf("Identifying changed files.")
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer func() {
-		ticker.Stop()
-		yellow.Printf("\n")
-	}()
+		workDir, _ = filepath.EvalSymlinks(workDir)
+	}
 
-	go func() {
-		for {
-			_, ok := <-ticker.C
-			if !ok {
-				break
+	if inWorkarea {
+		if modulePath != "" {
+			// Modules can live anywhere so just check out into a directory with the same name as the git root
+			rootDir = path.Join(workDir, path.Base(gitRoot))
+		} else {
+			if err := os.Setenv("GOPATH", strings.Join([]string{workDir, os.Getenv("GOPATH")}, ":")); err != nil {
+				return Workspace{}, &WorkspaceError{Op: "set GOPATH", Err: err}
 			}
-			yellow.Printf(".")
+
+			rootDir = path.Join(workDir, "src", rootPackage)
 		}
-	}()
+	}
 
-	updatedFilesChan := make(chan []string, 1)
-	locallyChangedFilesChan := make(chan []string, 1)
-	updatedDirsChan := make(chan []string, 1)
+	var changes fileChanges
+	var locallyChangedFiles []string
+	var changedLines map[string][]LineRange
+	diffErrs := make(chan error, 3)
 
 	go func() {
-		updatedFilesChan <- getUpdatedFiles(gitRoot, pathSpec, gitRevSpec, staging)
+		var err error
+		changes, err = getFileChanges(gitRoot, pathSpec, gitRevSpec, staging)
+		diffErrs <- err
 	}()
 
 	go func() {
-		locallyChangedFilesChan <- getLocallyChangedFiles(gitRoot, pathSpec)
+		var err error
+		locallyChangedFiles, err = getLocallyChangedFiles(gitRoot, pathSpec)
+		diffErrs <- err
 	}()
 
 	go func() {
-		updatedDirsChan <- getUpdatedDirs(gitRoot, pathSpec, gitRevSpec, staging)
+		var err error
+		changedLines, err = getChangedLines(gitRoot, pathSpec, gitRevSpec, staging)
+		diffErrs <- err
 	}()
 
 	// Try to create a shadow copy instead of checking out all the files
@@ -95,99 +173,219 @@ func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string,
 		} else if _, err := RunCmd("which", "lndir"); err == nil {
 			lndir = "lndir"
 		} else {
-			Failf("Unable to find go-lndir or lndir")
+			return Workspace{}, &WorkspaceError{Op: "create links", Err: errors.New("go-lndir or lndir not found")}
 		}
 	}
 
 	// Check out revSpec to test if we've been given one
+	var mostRecentSha string
 	if gitRevSpec != "" {
-		shas := MustRunCmd("git", "-C", gitRoot, "rev-list", gitRevSpec)
+		shas, err := RunGit(gitRoot, "rev-list", gitRevSpec)
+		if err != nil {
+			return Workspace{}, err
+		}
 		if len(shas) == 0 {
-			log.Fatalf(`Could not find any SHAS in range "%s"`, gitRevSpec)
+			return Workspace{}, &WorkspaceError{Op: "check out " + gitRevSpec, Err: fmt.Errorf(`could not find any SHAs in range "%s"`, gitRevSpec)}
 		}
-		mostRecentSha := strings.Fields(shas)[0]
-		if err := os.MkdirAll(rootDir, os.ModePerm); err != nil {
-			return Workspace{}, err
+		mostRecentSha = strings.Fields(shas)[0]
+		if worktree {
+			if err := checkoutWorktree(gitRoot, rootDir, mostRecentSha); err != nil {
+				return Workspace{}, err
+			}
+		} else if warm {
+			if err := syncWarmWorkarea(gitRoot, workDir, rootDir, mostRecentSha, keep); err != nil {
+				return Workspace{}, &WorkspaceError{Op: "update workarea", Err: err}
+			}
+		} else {
+			if err := os.MkdirAll(rootDir, os.ModePerm); err != nil {
+				return Workspace{}, &WorkspaceError{Op: "create workarea", Err: err}
+			}
+			if err := checkoutRevision(gitRoot, rootDir, filepath.Join(workDir, "index"), mostRecentSha); err != nil {
+				return Workspace{}, err
+			}
 		}
-		MustRunCmd("git", "-C", gitRoot, "--work-tree", rootDir, "checkout", mostRecentSha, "--", ".")
 	} else if staging {
-		if lndir != "" {
+		if worktree {
+			if err := checkoutIndexIntoWorktree(gitRoot, rootDir); err != nil {
+				return Workspace{}, err
+			}
+		} else if warm {
+			if err := syncWarmWorkarea(gitRoot, workDir, rootDir, "", keep); err != nil {
+				return Workspace{}, &WorkspaceError{Op: "update workarea", Err: err}
+			}
+		} else if lndir != "" {
 			absGitRoot, err := filepath.Abs(gitRoot)
 			if err != nil {
-				return Workspace{}, err
+				return Workspace{}, &WorkspaceError{Op: "find git root", Err: err}
 			}
 			if err := os.MkdirAll(rootDir, os.ModePerm); err != nil {
-				return Workspace{}, err
+				return Workspace{}, &WorkspaceError{Op: "create workarea", Err: err}
 			}
 			// Start with a copy of the current workspace
-			MustRunCmd(lndir, append(lndirArgs, absGitRoot, rootDir)...)
+			if output, err := RunCmd(lndir, append(lndirArgs, absGitRoot, rootDir)...); err != nil {
+				return Workspace{}, &WorkspaceError{Op: "create links", Err: cmdError(err, output)}
+			}
 
 			// Copy out any files that have local changes from the index
 			cmd := fmt.Sprintf("git ls-files --modified --deleted | git checkout-index --stdin -f --prefix %s/", rootDir)
-			MustRunCmd("sh", "-c", cmd)
+			if output, err := RunCmd("sh", "-c", cmd); err != nil {
+				return Workspace{}, &GitError{Args: []string{"checkout-index", "--stdin"}, Output: output, Err: err}
+			}
 
 			// Finally, copy out the files we want to test
-			MustRunCmd("git", "-C", gitRoot, "checkout-index", "-f", "--prefix", rootDir+"/")
-		} else {
-			MustRunCmd("git", "-C", gitRoot, "checkout-index", "-a", "--prefix", rootDir+"/")
+			if _, err := RunGit(gitRoot, "checkout-index", "-f", "--prefix", rootDir+"/"); err != nil {
+				return Workspace{}, err
+			}
+		} else if _, err := RunGit(gitRoot, "checkout-index", "-a", "--prefix", rootDir+"/"); err != nil {
+			return Workspace{}, err
+		}
+	}
+
+	modules, err := findModules(rootDir)
+	if err != nil {
+		return Workspace{}, &WorkspaceError{Op: "find modules", Err: err}
+	}
+
+	if modulePath != "" && rootDir != gitRoot {
+		if err := setupModuleEnv(rootDir, modules); err != nil {
+			return Workspace{}, &WorkspaceError{Op: "set up module environment", Err: err}
 		}
 	}
 
 	if err := os.Chdir(rootDir); err != nil {
-		return Workspace{}, err
+		return Workspace{}, &WorkspaceError{Op: "change to workarea", Err: err}
 	}
 
-	updatedDirs := <-updatedDirsChan
-	updatedPackages := getUpdatedPackages(rootPackage, updatedDirs)
+	for i := 0; i < cap(diffErrs); i++ {
+		if err := <-diffErrs; err != nil {
+			return Workspace{}, err
+		}
+	}
 
-	updatedFiles := <-updatedFilesChan
-	locallyChangedFiles := <-locallyChangedFilesChan
+	updatedDirs := getUpdatedDirs(changes.allFiles())
+
+	// Group the updated dirs by the module that owns them.  Anything outside of a module is resolved through GOPATH.
+	var gopathDirs []string
+	moduleDirs := make([][]string, len(modules))
+	for _, d := range updatedDirs {
+		if i := owningModule(modules, d); i >= 0 {
+			moduleDirs[i] = append(moduleDirs[i], d)
+		} else {
+			gopathDirs = append(gopathDirs, d)
+		}
+	}
+
+	// Every module is listed, even those without changes, since they may import updated packages
+	var updatedPackages []string
+	importers := map[string][]string{}
+	if len(gopathDirs) > 0 {
+		packages, err := listPackages("")
+		if err != nil {
+			return Workspace{}, err
+		}
+		importedBy(packages, importers)
+		updatedPackages = getUpdatedPackages(rootPackage, packages, gopathDirs)
+	}
+	for i := range modules {
+		packages, err := listPackages(modules[i].Dir)
+		if err != nil {
+			return Workspace{}, err
+		}
+		importedBy(packages, importers)
+		if len(moduleDirs[i]) == 0 {
+			continue
+		}
+		modulePackages := getUpdatedModulePackages(modules[i], packages, moduleDirs[i])
+		modules[i].UpdatedDirs = utils.SortStrings(moduleDirs[i])
+		modules[i].UpdatedPackages = utils.SortStrings(modulePackages)
+		updatedPackages = append(updatedPackages, modulePackages...)
+	}
+
+	changedLinesFile, err := writeChangedLinesFile(workDir, inWorkarea, changedLines)
+	if err != nil {
+		return Workspace{}, &WorkspaceError{Op: "write changed lines", Err: err}
+	}
 
 	return Workspace{
 		GitDir:              gitRoot,
 		WorkDir:             workDir,
 		RootDir:             rootDir,
-		UpdatedFiles:        utils.SortStrings(updatedFiles),
+		ModulePath:          modulePath,
+		Revision:            mostRecentSha,
+		Modules:             modules,
+		UpdatedFiles:        utils.SortStrings(changes.updatedFiles()),
+		AddedFiles:          utils.SortStrings(changes.added),
+		ModifiedFiles:       utils.SortStrings(changes.modified),
+		DeletedFiles:        utils.SortStrings(changes.deleted),
+		RenamedFiles:        changes.renamed,
 		UpdatedDirs:         utils.SortStrings(updatedDirs),
 		UpdatedPackages:     utils.SortStrings(updatedPackages),
 		UpdatedTrees:        utils.SortStrings(utils.ShortestPrefixes(updatedDirs)),
 		LocallyChangedFiles: utils.SortStrings(locallyChangedFiles),
-		deleteOnClose:       gitRevSpec != "" || staging,
+		importers:           importers,
+		ChangedLines:        changedLines,
+		ChangedLinesFile:    changedLinesFile,
+		deleteOnClose:       deleteOnClose,
+		worktree:            worktree,
+		lockFile:            lockFile,
 	}, nil
 }
-func getLocallyChangedFiles(gitRoot string, pathSpec []string) []string {
-	return strings.Fields(MustRunCmd("git", append([]string{"-C", gitRoot, "diff", "--name-only", "--diff-filter=ACMR", "--"}, pathSpec...)...))
+func getLocallyChangedFiles(gitRoot string, pathSpec []string) ([]string, error) {
+	output, err := RunGit(gitRoot, append([]string{"diff", "--name-only", "--diff-filter=ACMR", "--"}, pathSpec...)...)
+	return strings.Fields(output), err
 }
 
-func getUpdatedFiles(gitRoot string, pathSpec []string, gitRevSpec string, staging bool) []string {
-	diffCmd := []string{"diff", "--name-only", "--diff-filter=ACMR"}
-	if gitRevSpec != "" {
-		diffCmd = append(diffCmd, gitRevSpec)
-	} else if staging {
-		diffCmd = append(diffCmd, "--cached")
-	} else {
-		diffCmd = append(diffCmd, "HEAD")
+// Modules that have updated packages
+func (ws Workspace) UpdatedModules() (modules []Module) {
+	for _, m := range ws.Modules {
+		if len(m.UpdatedPackages) > 0 {
+			modules = append(modules, m)
+		}
+	}
+	return
+}
+
+// Writes the changed lines as JSON into the workarea, or into a temporary file if the workarea is the git root
+func writeChangedLinesFile(workDir string, inWorkarea bool, changedLines map[string][]LineRange) (string, error) {
+	data, err := json.MarshalIndent(changedLines, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if inWorkarea {
+		fileName := filepath.Join(workDir, changedLinesFileName)
+		return fileName, ioutil.WriteFile(fileName, data, 0644)
 	}
-	diffCmd = append(diffCmd, "--")
-	diffCmd = append(diffCmd, pathSpec...)
-	return strings.Fields(MustRunCmd("git", append([]string{"-C", gitRoot}, diffCmd...)...))
+
+	file, err := ioutil.TempFile("", changedLinesFileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	return file.Name(), err
 }
 
 func (ws Workspace) Close() error {
+	if ws.lockFile != "" {
+		defer os.Remove(ws.lockFile)
+	}
 	if !ws.deleteOnClose {
+		if ws.ChangedLinesFile != "" {
+			return os.Remove(ws.ChangedLinesFile)
+		}
 		return nil
 	}
 	return os.RemoveAll(ws.WorkDir)
 }
 
-// Must be run in rootDir
-func getUpdatedPackages(rootPackage string, updatedDirs []string) []string {
-	packages := strings.Fields(MustRunCmd("go", "list", "./..."))
+// Returns the GOPATH packages that are in one of the updated dirs.  rootPackage is the import path of the root dir.
+func getUpdatedPackages(rootPackage string, packages map[string][]string, updatedDirs []string) []string {
 	updatedPackages := map[string]bool{}
 
 	updatedDirMap := utils.StrMap(updatedDirs)
 
-	for _, p := range packages {
+	for p := range packages {
 		dirName := strings.TrimPrefix(p, rootPackage+"/")
 		if dirName == rootPackage {
 			dirName = "."
@@ -200,23 +398,8 @@ func getUpdatedPackages(rootPackage string, updatedDirs []string) []string {
 	return utils.StrKeys(updatedPackages)
 }
 
-func getUpdatedDirs(gitRoot string, pathSpec []string, gitRevSpec string, staging bool) []string {
-	diffCmd := []string{"diff", "--name-status", "--diff-filter=ACDMR"}
-	if gitRevSpec != "" {
-		diffCmd = append(diffCmd, gitRevSpec)
-	} else if staging {
-		diffCmd = append(diffCmd, "--cached")
-	} else {
-		diffCmd = append(diffCmd, "HEAD")
-	}
-	diffCmd = append(diffCmd, "--")
-	diffCmd = append(diffCmd, pathSpec...)
-	fileStatus := MustRunCmd("git", append([]string{"-C", gitRoot}, diffCmd...)...)
-	scanner := bufio.NewScanner(strings.NewReader(fileStatus))
-	var allFiles []string
-	for scanner.Scan() {
-		allFiles = append(allFiles, strings.Fields(scanner.Text())[1:]...)
-	}
+// Must be run in rootDir.  Returns the directories of files that still exist.
+func getUpdatedDirs(allFiles []string) []string {
 	updatedDirs := map[string]bool{}
 	for _, f := range allFiles {
 		updatedDirs[filepath.Dir(f)] = true
@@ -231,3 +414,13 @@ func getUpdatedDirs(gitRoot string, pathSpec []string, gitRevSpec string, stagin
 
 	return existingDirs
 }
+
+// Checks out sha into rootDir through an index of our own, so that the one in use is left alone
+func checkoutRevision(gitRoot string, rootDir string, indexFile string, sha string) error {
+	defer os.Remove(indexFile)
+	if _, err := runIndexGit(gitRoot, indexFile, nil, "read-tree", sha); err != nil {
+		return err
+	}
+	_, err := runIndexGit(gitRoot, indexFile, nil, "checkout-index", "-a", "-f", "--prefix", rootDir+"/")
+	return err
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartRevisionLeavesIndexAlone(t *testing.T) {
+	// Start changes to the workarea and sets up the environment for modules, so put them back afterwards
+	dir, err := os.Getwd()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Chdir(dir)
+	for _, name := range []string{"GO111MODULE", "GOWORK", "GOFLAGS"} {
+		t.Setenv(name, os.Getenv(name))
+	}
+
+	repo := newTestRepo(t)
+	repo.write(repo.Root, "go.mod", "module example.com/repo\n")
+	repo.write(repo.Root, "a.go", "package a")
+	repo.git("add", "go.mod", "a.go")
+	repo.commit("first")
+	repo.write(repo.Root, "a.go", "package a // second")
+	repo.git("add", "a.go")
+	second := repo.commit("second")
+
+	repo.write(repo.Root, "a.go", "package a // staged")
+	repo.write(repo.Root, "b.go", "package b")
+	repo.git("add", "a.go", "b.go")
+	index := repo.git("ls-files", "--stage")
+
+	ws, err := Start(repo.Root, []string{"*.go"}, false, second+"^!", false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ws.Close()
+	assert.Equal(t, "package a // second", repo.read(ws.RootDir, "a.go"))
+	assert.Equal(t, "<missing>", repo.read(ws.RootDir, "b.go"))
+	assert.Equal(t, []string{"a.go"}, ws.ModifiedFiles)
+	assert.Equal(t, index, repo.git("ls-files", "--stage"), "the git index is left alone")
+}
+
+func TestStartWithoutModule(t *testing.T) {
+	dir, err := os.Getwd()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Chdir(dir)
+	for _, name := range []string{"GO111MODULE", "GOPATH"} {
+		t.Setenv(name, os.Getenv(name))
+	}
+	os.Unsetenv("GO111MODULE")
+
+	repo := newTestRepo(t)
+	repo.write(repo.Root, "a.go", "package a")
+	repo.git("add", "a.go")
+
+	ws, err := Start(repo.Root, []string{"*.go"}, false, "", true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ws.Close()
+	assert.Equal(t, "off", os.Getenv("GO111MODULE"), "commands run in GOPATH mode")
+	assert.Equal(t, "package a", repo.read(ws.RootDir, "a.go"))
+}
@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Variables git sets for hooks that point at the user's checkout, which git commands in a worktree must not see
+var hookGitEnv = []string{"GIT_DIR", "GIT_WORK_TREE", "GIT_INDEX_FILE", "GIT_PREFIX"}
+
+// Returns the directory in the git dir where gogitix keeps the workareas it reuses
+func gogitixDir(gitRoot string) (string, error) {
+	output, err := RunGit(gitRoot, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(output)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitRoot, dir)
+	}
+	return filepath.Join(dir, "gogitix"), nil
+}
+
+// Takes the lock on a workarea that is reused between runs, returning the lock file to remove to give it back.  A lock
+// left behind by a run that is no longer running is taken over.
+func lockWorkarea(workDir string) (string, error) {
+	lockFile := workDir + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockFile), os.ModePerm); err != nil {
+		return "", err
+	}
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			if attempt == 0 {
+				if removed, err := removeStaleLock(lockFile); err != nil {
+					return "", err
+				} else if removed {
+					continue
+				}
+			}
+			return "", fmt.Errorf("%s is being used by another run of gogitix (remove %s if there isn't one)", workDir, lockFile)
+		} else if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		_, err = fmt.Fprintf(file, "%d\n", os.Getpid())
+		return lockFile, err
+	}
+}
+
+// Removes a lock left behind by a run that is no longer running, returning whether it did.  Only one run does this at
+// a time, and it checks the owner once it's the one doing it, so that it can't remove a lock another run has just taken
+// over.
+func removeStaleLock(lockFile string) (bool, error) {
+	takeoverFile := lockFile + ".takeover"
+	file, err := os.OpenFile(takeoverFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	file.Close()
+	defer os.Remove(takeoverFile)
+
+	pid, found := lockOwner(lockFile)
+	if !found || processExists(pid) {
+		return false, nil
+	}
+	if debug {
+		color.Magenta("[DEBUG] taking over the lock %s from process %d, which is no longer running", lockFile, pid)
+	}
+	if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns the pid written in a lock file, if there is one yet
+func lockOwner(lockFile string) (int, bool) {
+	data, err := ioutil.ReadFile(lockFile)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil
+}
+
+// The environment for commands run in a worktree, so that git finds the worktree rather than the user's checkout
+func worktreeEnv() []string {
+	var env []string
+	for _, v := range os.Environ() {
+		hookVar := false
+		for _, name := range hookGitEnv {
+			if strings.HasPrefix(v, name+"=") {
+				hookVar = true
+			}
+		}
+		if !hookVar {
+			env = append(env, v)
+		}
+	}
+	return env
+}
+
+func runWorktreeGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...) // #nosec
+	cmd.Env = worktreeEnv()
+	if debug {
+		color.Magenta("[DEBUG] running 'git -C %s %s'", dir, strings.Join(args, " "))
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), &GitError{Args: args, Output: string(output), Err: err}
+	}
+	return string(output), nil
+}
+
+// Checks out sha into a worktree at dir, reusing the worktree from the last run if there is one.  Anything left behind
+// by the last run is removed.
+func checkoutWorktree(gitRoot string, dir string, sha string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		if _, err := runWorktreeGit(dir, "checkout", "-q", "--detach", "--force", sha); err != nil {
+			return err
+		}
+		_, err := runWorktreeGit(dir, "clean", "-q", "-d", "-x", "-f", "-f")
+		return err
+	}
+
+	// Forget about a worktree that was removed without telling git.  These run without the hook's variables too, or
+	// adding the worktree would check it out into the index being committed.
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if _, err := runWorktreeGit(gitRoot, "worktree", "prune"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), os.ModePerm); err != nil {
+		return err
+	}
+	_, err := runWorktreeGit(gitRoot, "worktree", "add", "-q", "--detach", "--force", dir, sha)
+	return err
+}
+
+// Makes the index and files of the worktree at dir match the git index, only rewriting the files that differ
+func checkoutIndexIntoWorktree(gitRoot string, dir string) error {
+	head, err := RunGit(gitRoot, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return err
+	}
+	if err := checkoutWorktree(gitRoot, dir, strings.TrimSpace(head)); err != nil {
+		return err
+	}
+	// Worktrees share objects, so the index can be passed along as a tree
+	tree, err := RunGit(gitRoot, "write-tree")
+	if err != nil {
+		return err
+	}
+	_, err = runWorktreeGit(dir, "read-tree", "-u", "--reset", strings.TrimSpace(tree))
+	return err
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorktree(t *testing.T) {
+	repo := newTestRepo(t)
+	gitRoot := repo.Root
+	git, write, read := repo.git, repo.write, repo.read
+	write(gitRoot, "a.go", "package a")
+	write(gitRoot, "b.go", "package b")
+	git("add", "a.go", "b.go")
+	first := repo.commit("first")
+
+	dir, err := gogitixDir(gitRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(gitRoot, ".git", "gogitix"), dir)
+	worktree := filepath.Join(dir, "worktree", "repo")
+
+	// Stage some changes and leave others in the working tree
+	write(gitRoot, "a.go", "package a // staged")
+	write(gitRoot, "c.go", "package c")
+	git("add", "a.go", "c.go")
+	git("rm", "-q", "b.go")
+	write(gitRoot, "a.go", "package a // not staged")
+	index := git("ls-files", "--stage")
+
+	assert.NoError(t, checkoutIndexIntoWorktree(gitRoot, worktree))
+	assert.Equal(t, "package a // staged", read(worktree, "a.go"))
+	assert.Equal(t, "<missing>", read(worktree, "b.go"))
+	assert.Equal(t, "package c", read(worktree, "c.go"))
+	assert.Equal(t, index, git("ls-files", "--stage"), "the git index is left alone")
+
+	// The worktree is reused, and anything left in it is removed
+	write(worktree, "d.go", "package d")
+	assert.NoError(t, checkoutWorktree(gitRoot, worktree, first))
+	assert.Equal(t, "package a", read(worktree, "a.go"))
+	assert.Equal(t, "package b", read(worktree, "b.go"))
+	assert.Equal(t, "<missing>", read(worktree, "c.go"))
+	assert.Equal(t, "<missing>", read(worktree, "d.go"))
+	assert.Equal(t, index, git("ls-files", "--stage"), "the git index is left alone")
+	assert.Equal(t, 2, len(strings.Split(git("worktree", "list"), "\n")))
+
+	// A worktree that was removed is made again
+	assert.NoError(t, os.RemoveAll(worktree))
+	assert.NoError(t, checkoutWorktree(gitRoot, worktree, first))
+	assert.Equal(t, "package b", read(worktree, "b.go"))
+
+	lockFile, err := lockWorkarea(filepath.Dir(worktree))
+	assert.NoError(t, err)
+	_, err = lockWorkarea(filepath.Dir(worktree))
+	assert.Error(t, err, "the workarea is already locked")
+	assert.NoError(t, os.Remove(lockFile))
+	lockFile, err = lockWorkarea(filepath.Dir(worktree))
+	assert.NoError(t, err)
+
+	// A lock left by a run that died is taken over, unless another run is already taking it over
+	exited := exec.Command("true")
+	assert.NoError(t, exited.Run())
+	write(filepath.Dir(lockFile), filepath.Base(lockFile), fmt.Sprintf("%d\n", exited.Process.Pid))
+	write(filepath.Dir(lockFile), filepath.Base(lockFile)+".takeover", "")
+	_, err = lockWorkarea(filepath.Dir(worktree))
+	assert.Error(t, err, "another run is taking over the lock")
+	assert.NoError(t, os.Remove(lockFile+".takeover"))
+	lockFile, err = lockWorkarea(filepath.Dir(worktree))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\n", os.Getpid()), read(filepath.Dir(lockFile), filepath.Base(lockFile)))
+	assert.Equal(t, "<missing>", read(filepath.Dir(lockFile), filepath.Base(lockFile)+".takeover"))
+	os.Remove(lockFile)
+}
+
+func TestWorktreeFromHook(t *testing.T) {
+	repo := newTestRepo(t)
+	gitRoot := repo.Root
+	repo.write(gitRoot, "a.go", "package a")
+	repo.git("add", "a.go")
+	repo.commit("first")
+	index := repo.git("ls-files", "--stage")
+
+	// "git commit -a" runs hooks with its own index, which is what gets committed
+	hookIndex := filepath.Join(gitRoot, ".git", "index.lock")
+	repo.write(gitRoot, "a.go", "package a // staged")
+	_, err := runIndexGit(gitRoot, hookIndex, nil, "add", "a.go")
+	assert.NoError(t, err)
+	hookIndexFiles, err := runIndexGit(gitRoot, hookIndex, nil, "ls-files", "--stage")
+	assert.NoError(t, err)
+	assert.NoError(t, os.Setenv("GIT_INDEX_FILE", hookIndex))
+	defer os.Unsetenv("GIT_INDEX_FILE")
+
+	dir, err := gogitixDir(gitRoot)
+	assert.NoError(t, err)
+	worktree := filepath.Join(dir, "worktree", "repo")
+	assert.NoError(t, checkoutIndexIntoWorktree(gitRoot, worktree))
+	assert.Equal(t, "package a // staged", repo.read(worktree, "a.go"))
+
+	os.Unsetenv("GIT_INDEX_FILE")
+	files, _ := runIndexGit(gitRoot, hookIndex, nil, "ls-files", "--stage")
+	assert.Equal(t, hookIndexFiles, files, "the index being committed is left alone")
+	assert.Equal(t, index, repo.git("ls-files", "--stage"), "the git index is left alone")
+}